@@ -0,0 +1,93 @@
+package collect
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Circuit breaker states, as reported by the collect.circuit metric.
+const (
+	// circuitClosed is normal operation.
+	circuitClosed = 0
+	// circuitHalfOpen is set while a single batch is sent to probe whether the
+	// host has recovered.
+	circuitHalfOpen = 1
+	// circuitOpen is set after BreakerThreshold consecutive failures. Nothing
+	// is sent until the current backoff expires.
+	circuitOpen = 2
+)
+
+var (
+	// MinBackoff is the wait after the first failed send. It doubles with each
+	// consecutive failure.
+	MinBackoff = time.Second
+
+	// MaxBackoff is the maximum wait between retries of a failed send.
+	MaxBackoff = time.Minute * 5
+
+	// BreakerThreshold is the number of consecutive failed sends after which
+	// the circuit breaker opens.
+	BreakerThreshold = 3
+)
+
+// breaker tracks consecutive send failures to compute retry delays and the
+// circuit breaker state.
+type breaker struct {
+	sync.Mutex
+	failures int
+	state    int
+}
+
+// success records a successful send and closes the circuit.
+func (b *breaker) success() {
+	b.Lock()
+	b.failures = 0
+	b.state = circuitClosed
+	b.Unlock()
+}
+
+// failure records a failed send and returns how long to wait before retrying.
+func (b *breaker) failure() time.Duration {
+	b.Lock()
+	defer b.Unlock()
+	b.failures++
+	if b.failures >= BreakerThreshold {
+		b.state = circuitOpen
+	}
+	return backoff(b.failures)
+}
+
+// probe marks the next send as a probe if the circuit is open.
+func (b *breaker) probe() {
+	b.Lock()
+	if b.state == circuitOpen {
+		b.state = circuitHalfOpen
+	}
+	b.Unlock()
+}
+
+// current returns the circuit breaker state.
+func (b *breaker) current() int {
+	b.Lock()
+	defer b.Unlock()
+	return b.state
+}
+
+// backoff returns the delay after n consecutive failures: MinBackoff doubled
+// for each failure, capped at MaxBackoff, with half of it randomized so that
+// many agents do not retry in lockstep.
+func backoff(n int) time.Duration {
+	d := MinBackoff
+	for i := 1; i < n && d < MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > MaxBackoff {
+		d = MaxBackoff
+	}
+	half := int64(d / 2)
+	if half <= 0 {
+		return d
+	}
+	return time.Duration(half + rand.Int63n(half))
+}
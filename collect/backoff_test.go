@@ -0,0 +1,77 @@
+package collect
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBreaker(t *testing.T) {
+	defer func(n int) { BreakerThreshold = n }(BreakerThreshold)
+	BreakerThreshold = 3
+	type step int
+	const (
+		success step = iota
+		failure
+		probe
+	)
+	for i, test := range []struct {
+		steps []step
+		state int
+	}{
+		{nil, circuitClosed},
+		{[]step{failure}, circuitClosed},
+		{[]step{failure, failure}, circuitClosed},
+		{[]step{failure, failure, failure}, circuitOpen},
+		{[]step{failure, failure, success, failure}, circuitClosed},
+		{[]step{probe}, circuitClosed},
+		{[]step{failure, probe}, circuitClosed},
+		{[]step{failure, failure, failure, probe}, circuitHalfOpen},
+		{[]step{failure, failure, failure, probe, probe}, circuitHalfOpen},
+		{[]step{failure, failure, failure, probe, failure}, circuitOpen},
+		{[]step{failure, failure, failure, probe, success}, circuitClosed},
+		{[]step{failure, failure, failure, success, failure}, circuitClosed},
+	} {
+		var b breaker
+		for _, s := range test.steps {
+			switch s {
+			case success:
+				b.success()
+			case failure:
+				b.failure()
+			case probe:
+				b.probe()
+			}
+		}
+		if got := b.current(); got != test.state {
+			t.Errorf("%d: state %d after %v, expected %d", i, got, test.steps, test.state)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	defer func(min, max time.Duration) { MinBackoff, MaxBackoff = min, max }(MinBackoff, MaxBackoff)
+	MinBackoff = time.Second
+	MaxBackoff = time.Minute
+	for _, test := range []struct {
+		n        int
+		min, max time.Duration // max is exclusive
+	}{
+		{1, time.Second / 2, time.Second},
+		{2, time.Second, 2 * time.Second},
+		{3, 2 * time.Second, 4 * time.Second},
+		{6, 16 * time.Second, 32 * time.Second},
+		{7, 30 * time.Second, time.Minute},
+		{100, 30 * time.Second, time.Minute},
+	} {
+		for i := 0; i < 1000; i++ {
+			if d := backoff(test.n); d < test.min || d >= test.max {
+				t.Fatalf("backoff(%d) = %v, expected [%v, %v)", test.n, d, test.min, test.max)
+			}
+		}
+	}
+	// A delay too short to halve is not randomized.
+	MinBackoff = time.Nanosecond
+	if d := backoff(1); d != time.Nanosecond {
+		t.Errorf("backoff(1) = %v, expected 1ns", d)
+	}
+}
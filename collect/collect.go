@@ -5,7 +5,6 @@
 package collect
 
 import (
-	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/url"
@@ -39,22 +38,32 @@ var (
 	// generated.
 	DisableDefaultCollectors = false
 
-	// Dropped is the number of dropped data points due to a full queue.
-	dropped int64
+	// SpoolDir, if set, is a directory where data points are stored when a
+	// destination's queue is full. Each destination spools to a subdirectory
+	// named after it. Spooled data is sent, oldest first, once the queue has
+	// drained, and is kept across restarts.
+	SpoolDir = ""
 
-	// Sent is the number of sent data points.
-	sent int64
+	// MaxSpoolSize is the maximum size in bytes of each destination's spool.
+	// Once reached, the oldest spooled data is discarded.
+	MaxSpoolSize int64 = 1 << 30
 
-	tchan               chan *opentsdb.DataPoint
-	tsdbURL             string
-	osHostname          string
-	metricRoot          string
-	queue               []json.RawMessage
-	qlock, mlock, slock sync.Mutex   // Locks for queues, maps, stats.
-	counters                         = make(map[string]*addMetric)
-	sets                             = make(map[string]*setMetric)
-	puts                             = make(map[string]*putMetric)
-	client              *http.Client = &http.Client{
+	// MaxSamples is the number of values kept per series by Sample to compute
+	// percentiles. Beyond it, a uniform random sample of the values is kept.
+	MaxSamples = 1028
+
+	tchan      chan *opentsdb.DataPoint
+	qsync      = make(chan chan struct{})
+	dests      []*Destination
+	osHostname string
+	hostLock   sync.Mutex // Lock for setting osHostname.
+	metricRoot string
+	mlock      sync.Mutex   // Lock for maps.
+	counters                = make(map[string]*addMetric)
+	sets                    = make(map[string]*setMetric)
+	puts                    = make(map[string]*putMetric)
+	samples                 = make(map[string]*sampleMetric)
+	client     *http.Client = &http.Client{
 		Transport: &timeoutTransport{Transport: new(http.Transport)},
 		Timeout:   time.Minute,
 	}
@@ -62,60 +71,62 @@ var (
 
 type timeoutTransport struct {
 	*http.Transport
+	sync.Mutex
 	Timeout time.Time
 }
 
 func (t *timeoutTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	t.Lock()
 	if time.Now().After(t.Timeout) {
 		t.Transport.CloseIdleConnections()
 		t.Timeout = time.Now().Add(time.Minute * 5)
 	}
+	t.Unlock()
 	return t.Transport.RoundTrip(r)
 }
 
 // InitChan is similar to Init, but uses the given channel instead of creating a
 // new one.
 func InitChan(tsdbhost *url.URL, metric_root string, ch chan *opentsdb.DataPoint) error {
+	d, err := NewOpenTSDB(tsdbhost)
+	if err != nil {
+		return err
+	}
+	return InitDestinations([]*Destination{d}, metric_root, ch)
+}
+
+// InitDestinations is similar to InitChan, but sends every data point to each
+// of destinations.
+func InitDestinations(destinations []*Destination, metric_root string, ch chan *opentsdb.DataPoint) error {
 	if tchan != nil {
 		return fmt.Errorf("cannot init twice")
 	}
 	if err := checkClean(metric_root, "metric root"); err != nil {
 		return err
 	}
-	u, err := tsdbhost.Parse("/api/put")
-	if err != nil {
-		return err
+	names := make(map[string]bool)
+	for _, d := range destinations {
+		if names[d.Name] {
+			return fmt.Errorf("duplicate destination: %s", d)
+		}
+		names[d.Name] = true
 	}
-	if strings.HasPrefix(u.Host, ":") {
-		u.Host = "localhost" + u.Host
+	for _, d := range destinations {
+		if err := d.start(); err != nil {
+			return err
+		}
 	}
-	tsdbURL = u.String()
+	dests = destinations
 	metricRoot = metric_root + "."
 	tchan = ch
 	go queuer()
-	go send()
 	go collect()
 	if DisableDefaultCollectors {
 		return nil
 	}
-	Set("collect.dropped", nil, func() (i interface{}) {
-		slock.Lock()
-		i = dropped
-		slock.Unlock()
-		return
-	})
-	Set("collect.sent", nil, func() (i interface{}) {
-		slock.Lock()
-		i = sent
-		slock.Unlock()
-		return
-	})
-	Set("collect.queued", nil, func() (i interface{}) {
-		qlock.Lock()
-		i = len(queue)
-		qlock.Unlock()
-		return
-	})
+	for _, d := range dests {
+		d.initStats()
+	}
 	Set("collect.alloc", nil, func() interface{} {
 		var ms runtime.MemStats
 		runtime.ReadMemStats(&ms)
@@ -162,6 +173,17 @@ func Set(metric string, ts opentsdb.TagSet, f func() interface{}) error {
 	return nil
 }
 
+// Unset removes the metric registered by Set with the same tags.
+func Unset(metric string, ts opentsdb.TagSet) error {
+	if err := check(metric, &ts); err != nil {
+		return err
+	}
+	mlock.Lock()
+	delete(sets, metric+ts.String())
+	mlock.Unlock()
+	return nil
+}
+
 type addMetric struct {
 	metric string
 	ts     opentsdb.TagSet
@@ -218,16 +240,20 @@ func check(metric string, ts *opentsdb.TagSet) error {
 			return err
 		}
 	}
+	hostLock.Lock()
 	if osHostname == "" {
 		if err := setHostName(); err != nil {
+			hostLock.Unlock()
 			return err
 		}
 	}
+	hostname := osHostname
+	hostLock.Unlock()
 	if *ts == nil {
 		*ts = make(opentsdb.TagSet)
 	}
 	if host, present := (*ts)["host"]; !present {
-		(*ts)["host"] = osHostname
+		(*ts)["host"] = hostname
 	} else if host == "" {
 		delete(*ts, "host")
 	}
@@ -244,39 +270,69 @@ func checkClean(s, t string) error {
 	return nil
 }
 
+// Shutdown sends the pending counters, sets, puts and samples, then waits
+// until every destination has sent its queue or deadline passes. Data still
+// queued is then spooled, if enabled, or abandoned. Collectors should be
+// stopped first. It returns the number of data points abandoned.
+func Shutdown(deadline time.Time) int {
+	if tchan == nil {
+		return 0
+	}
+	flush()
+	c := make(chan struct{})
+	qsync <- c
+	<-c
+	abandoned := 0
+	for _, d := range dests {
+		abandoned += d.drain(deadline)
+	}
+	return abandoned
+}
+
 func collect() {
 	for {
-		mlock.Lock()
-		now := time.Now().Unix()
-		for _, c := range counters {
-			dp := &opentsdb.DataPoint{
-				Metric:    metricRoot + c.metric,
-				Timestamp: now,
-				Value:     c.value,
-				Tags:      c.ts,
-			}
-			tchan <- dp
+		flush()
+		time.Sleep(Freq)
+	}
+}
+
+// flush sends the current value of all counters, sets, puts and samples.
+func flush() {
+	mlock.Lock()
+	now := time.Now().Unix()
+	for _, c := range counters {
+		dp := &opentsdb.DataPoint{
+			Metric:    metricRoot + c.metric,
+			Timestamp: now,
+			Value:     c.value,
+			Tags:      c.ts,
 		}
-		for _, s := range sets {
-			dp := &opentsdb.DataPoint{
-				Metric:    metricRoot + s.metric,
-				Timestamp: now,
-				Value:     s.f(),
-				Tags:      s.ts,
-			}
-			tchan <- dp
+		tchan <- dp
+	}
+	for _, s := range sets {
+		dp := &opentsdb.DataPoint{
+			Metric:    metricRoot + s.metric,
+			Timestamp: now,
+			Value:     s.f(),
+			Tags:      s.ts,
 		}
-		for _, s := range puts {
-			dp := &opentsdb.DataPoint{
-				Metric:    metricRoot + s.metric,
-				Timestamp: now,
-				Value:     s.value,
-				Tags:      s.ts,
-			}
+		tchan <- dp
+	}
+	for _, s := range puts {
+		dp := &opentsdb.DataPoint{
+			Metric:    metricRoot + s.metric,
+			Timestamp: now,
+			Value:     s.value,
+			Tags:      s.ts,
+		}
+		tchan <- dp
+	}
+	puts = make(map[string]*putMetric)
+	for _, s := range samples {
+		for _, dp := range s.dataPoints(now) {
 			tchan <- dp
 		}
-		puts = make(map[string]*putMetric)
-		mlock.Unlock()
-		time.Sleep(Freq)
 	}
+	samples = make(map[string]*sampleMetric)
+	mlock.Unlock()
 }
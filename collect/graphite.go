@@ -0,0 +1,182 @@
+package collect
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bosun-monitor/scollector/opentsdb"
+)
+
+// DefaultGraphiteTemplate is the template used by NewGraphite if none is
+// given.
+const DefaultGraphiteTemplate = "host.metric.tagk.tagv"
+
+// graphiteSender writes the Carbon plaintext protocol over TCP.
+type graphiteSender struct {
+	addr     string
+	template []string
+	conn     net.Conn
+}
+
+// NewGraphite returns a destination that writes to the Carbon plaintext
+// listener at addr (host:port). Tags are flattened into the metric path
+// according to template, a dot-separated list of components:
+//
+//	metric     the metric name
+//	tagk.tagv  each remaining tag as its key then value, sorted by key
+//	<other>    the value of the tag with that key, if present
+//
+// For example, "host.metric.tagk.tagv" sends os.cpu{host=ny-web01,type=idle}
+// as ny-web01.os.cpu.type.idle.
+func NewGraphite(addr, template string) (*Destination, error) {
+	if template == "" {
+		template = DefaultGraphiteTemplate
+	}
+	t, err := parseGraphiteTemplate(template)
+	if err != nil {
+		return nil, err
+	}
+	return newDestination(opentsdb.MustReplace(addr, "_"), &graphiteSender{
+		addr:     addr,
+		template: t,
+	})
+}
+
+func parseGraphiteTemplate(template string) ([]string, error) {
+	var t []string
+	sp := strings.Split(template, ".")
+	hasMetric := false
+	for i := 0; i < len(sp); i++ {
+		switch sp[i] {
+		case "":
+			return nil, fmt.Errorf("graphite: empty component in template %q", template)
+		case "tagk":
+			if i+1 == len(sp) || sp[i+1] != "tagv" {
+				return nil, fmt.Errorf("graphite: tagk must be followed by tagv in template %q", template)
+			}
+			i++
+			t = append(t, "tagk.tagv")
+			continue
+		case "tagv":
+			return nil, fmt.Errorf("graphite: tagv must follow tagk in template %q", template)
+		case "metric":
+			hasMetric = true
+		}
+		t = append(t, sp[i])
+	}
+	if !hasMetric {
+		return nil, fmt.Errorf("graphite: template %q does not contain metric", template)
+	}
+	return t, nil
+}
+
+func (g *graphiteSender) encode(dp *opentsdb.DataPoint) ([]byte, error) {
+	v, err := valueString(dp.Value)
+	if err != nil {
+		return nil, err
+	}
+	used := make(map[string]bool)
+	for _, c := range g.template {
+		if _, ok := dp.Tags[c]; ok {
+			used[c] = true
+		}
+	}
+	var b bytes.Buffer
+	add := func(s string) {
+		if b.Len() > 0 {
+			b.WriteByte('.')
+		}
+		b.WriteString(s)
+	}
+	for _, c := range g.template {
+		switch c {
+		case "metric":
+			for _, s := range strings.Split(dp.Metric, ".") {
+				add(graphiteClean(s))
+			}
+		case "tagk.tagv":
+			var keys []string
+			for k := range dp.Tags {
+				if !used[k] {
+					keys = append(keys, k)
+				}
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				add(graphiteClean(k))
+				add(graphiteClean(dp.Tags[k]))
+			}
+		default:
+			if tv, ok := dp.Tags[c]; ok {
+				add(graphiteClean(tv))
+			}
+		}
+	}
+	fmt.Fprintf(&b, " %s %d", v, dp.Timestamp)
+	return b.Bytes(), nil
+}
+
+// graphiteClean replaces characters that are not safe in a Graphite path
+// component with _.
+func graphiteClean(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_':
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+func (g *graphiteSender) send(batch [][]byte) error {
+	if g.conn == nil {
+		c, err := net.DialTimeout("tcp", g.addr, time.Second*10)
+		if err != nil {
+			return err
+		}
+		g.conn = c
+	}
+	var b bytes.Buffer
+	for _, r := range batch {
+		b.Write(r)
+		b.WriteByte('\n')
+	}
+	g.conn.SetWriteDeadline(time.Now().Add(time.Minute))
+	if _, err := b.WriteTo(g.conn); err != nil {
+		g.conn.Close()
+		g.conn = nil
+		return err
+	}
+	return nil
+}
+
+// valueString formats a data point value as a plain number.
+func valueString(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', -1, 32), nil
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, *big.Int:
+		return fmt.Sprint(v), nil
+	case json.Number:
+		if _, err := v.Float64(); err != nil {
+			return "", fmt.Errorf("unparseable number %v", v)
+		}
+		return v.String(), nil
+	case string:
+		if _, err := strconv.ParseFloat(v, 64); err != nil {
+			return "", fmt.Errorf("unparseable number %v", v)
+		}
+		return v, nil
+	default:
+		return "", fmt.Errorf("unsupported value type %T", v)
+	}
+}
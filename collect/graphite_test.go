@@ -0,0 +1,42 @@
+package collect
+
+import (
+	"testing"
+
+	"github.com/bosun-monitor/scollector/opentsdb"
+)
+
+func TestGraphiteEncode(t *testing.T) {
+	dp := &opentsdb.DataPoint{
+		Metric:    "os.disk.fs.space_free",
+		Timestamp: 1434055562,
+		Value:     1.5,
+		Tags:      opentsdb.TagSet{"host": "ny-web01", "disk": "/var/log", "dc": "ny"},
+	}
+	tests := []struct {
+		template string
+		line     string
+	}{
+		{"", "ny-web01.os.disk.fs.space_free.dc.ny.disk._var_log 1.5 1434055562"},
+		{"dc.host.metric.tagk.tagv", "ny.ny-web01.os.disk.fs.space_free.disk._var_log 1.5 1434055562"},
+		{"metric.host.missing", "os.disk.fs.space_free.ny-web01 1.5 1434055562"},
+	}
+	for _, test := range tests {
+		d, err := NewGraphite("localhost:2003", test.template)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := d.sender.encode(dp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != test.line {
+			t.Errorf("%q: got %q, expected %q", test.template, b, test.line)
+		}
+	}
+	for _, bad := range []string{"host.tagk", "tagv.metric", "host..metric", "host.tagk.tagv"} {
+		if _, err := NewGraphite("localhost:2003", bad); err == nil {
+			t.Errorf("%q: expected error", bad)
+		}
+	}
+}
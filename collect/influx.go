@@ -0,0 +1,97 @@
+package collect
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/bosun-monitor/scollector/opentsdb"
+)
+
+// influxSender sends gzipped InfluxDB line protocol to the /write endpoint.
+type influxSender struct {
+	url  string
+	user *url.Userinfo
+}
+
+// NewInflux returns a destination that writes to database db of the InfluxDB
+// at host using the line protocol. The metric becomes the measurement, tags
+// become tags, and the value is stored in the field "value". Credentials in
+// host are sent with basic authentication.
+func NewInflux(host *url.URL, db string) (*Destination, error) {
+	if db == "" {
+		return nil, fmt.Errorf("influx: missing database")
+	}
+	u, err := host.Parse("/write")
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasPrefix(u.Host, ":") {
+		u.Host = "localhost" + u.Host
+	}
+	u.User = nil
+	u.RawQuery = url.Values{"db": {db}, "precision": {"s"}}.Encode()
+	return newDestination(opentsdb.MustReplace(u.Host, "_"), &influxSender{
+		url:  u.String(),
+		user: host.User,
+	})
+}
+
+var (
+	influxMeasurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+	influxTagEscaper         = strings.NewReplacer(",", `\,`, " ", `\ `, "=", `\=`)
+)
+
+func (i *influxSender) encode(dp *opentsdb.DataPoint) ([]byte, error) {
+	v, err := valueString(dp.Value)
+	if err != nil {
+		return nil, err
+	}
+	var b bytes.Buffer
+	b.WriteString(influxMeasurementEscaper.Replace(dp.Metric))
+	keys := make([]string, 0, len(dp.Tags))
+	for k := range dp.Tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(&b, ",%s=%s", influxTagEscaper.Replace(k), influxTagEscaper.Replace(dp.Tags[k]))
+	}
+	fmt.Fprintf(&b, " value=%s %d", v, dp.Timestamp)
+	return b.Bytes(), nil
+}
+
+func (i *influxSender) send(batch [][]byte) error {
+	var buf bytes.Buffer
+	g := gzip.NewWriter(&buf)
+	for _, r := range batch {
+		g.Write(r)
+		g.Write([]byte{'\n'})
+	}
+	if err := g.Close(); err != nil {
+		return rejected{err}
+	}
+	req, err := http.NewRequest("POST", i.url, &buf)
+	if err != nil {
+		return rejected{err}
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	req.Header.Set("Content-Encoding", "gzip")
+	if i.user != nil {
+		p, _ := i.user.Password()
+		req.SetBasicAuth(i.user.Username(), p)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	return responseError(resp)
+}
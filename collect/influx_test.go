@@ -0,0 +1,68 @@
+package collect
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/bosun-monitor/scollector/opentsdb"
+)
+
+func TestInflux(t *testing.T) {
+	var body, query, user string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.RawQuery
+		user, _, _ = r.BasicAuth()
+		if r.URL.Path != "/write" || r.Header.Get("Content-Encoding") != "gzip" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		g, err := gzip.NewReader(r.Body)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		b, err := ioutil.ReadAll(g)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		body = string(b)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+	u, _ := url.Parse(ts.URL)
+	u.User = url.UserPassword("scollector", "secret")
+	d, err := NewInflux(u, "metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var batch [][]byte
+	for _, dp := range []*opentsdb.DataPoint{
+		{Metric: "os.cpu", Timestamp: 1434055562, Value: 42, Tags: opentsdb.TagSet{"host": "ny-web01"}},
+		{Metric: "linux.disk.fs.space_free", Timestamp: 1434055562, Value: "1.5", Tags: opentsdb.TagSet{"mount": "/my disk", "host": "ny-web01"}},
+	} {
+		b, err := d.sender.encode(dp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		batch = append(batch, b)
+	}
+	if err := d.sender.send(batch); err != nil {
+		t.Fatal(err)
+	}
+	const expect = "os.cpu,host=ny-web01 value=42 1434055562\n" +
+		"linux.disk.fs.space_free,host=ny-web01,mount=/my\\ disk value=1.5 1434055562\n"
+	if body != expect {
+		t.Errorf("got:\n%s\nexpected:\n%s", body, expect)
+	}
+	if query != "db=metrics&precision=s" {
+		t.Errorf("unexpected query: %s", query)
+	}
+	if user != "scollector" {
+		t.Errorf("unexpected user: %s", user)
+	}
+}
@@ -0,0 +1,175 @@
+package collect
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/StackExchange/slog"
+	"github.com/bosun-monitor/scollector/metadata"
+	"github.com/bosun-monitor/scollector/opentsdb"
+)
+
+// PrometheusExpire is how long a series is exposed after its last update.
+var PrometheusExpire = time.Hour
+
+var prom *promStore
+
+// promStore holds the latest value of each series for scraping.
+type promStore struct {
+	sync.Mutex
+	series map[string]*promSeries
+}
+
+type promSeries struct {
+	metric  string
+	tags    opentsdb.TagSet
+	value   string
+	updated time.Time
+}
+
+// ListenPrometheus serves the latest value of every series in the Prometheus
+// text exposition format at http://addr/metrics. It must be called before
+// Init.
+func ListenPrometheus(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	prom = &promStore{series: make(map[string]*promSeries)}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", prom)
+	go func() {
+		slog.Error(http.Serve(l, mux))
+	}()
+	return nil
+}
+
+func (p *promStore) observe(dp *opentsdb.DataPoint) {
+	v, err := valueString(dp.Value)
+	if err != nil {
+		return
+	}
+	key := dp.Metric + dp.Tags.String()
+	p.Lock()
+	p.series[key] = &promSeries{
+		metric:  dp.Metric,
+		tags:    dp.Tags,
+		value:   v,
+		updated: time.Now(),
+	}
+	p.Unlock()
+}
+
+func (p *promStore) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var b bytes.Buffer
+	p.write(&b)
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	b.WriteTo(w)
+}
+
+// write writes all current series to w, grouped into metric families.
+func (p *promStore) write(w io.Writer) {
+	families := make(map[string][]*promSeries)
+	expire := time.Now().Add(-PrometheusExpire)
+	p.Lock()
+	for k, s := range p.series {
+		if s.updated.Before(expire) {
+			delete(p.series, k)
+			continue
+		}
+		name := promName(s.metric)
+		families[name] = append(families[name], s)
+	}
+	p.Unlock()
+	names := make([]string, 0, len(families))
+	for name := range families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		series := families[name]
+		sort.Sort(byTags(series))
+		metric := series[0].metric
+		if desc, ok := metadata.Lookup(metric, "desc").(string); ok && desc != "" {
+			fmt.Fprintf(w, "# HELP %s %s\n", name, promHelpEscaper.Replace(desc))
+		}
+		fmt.Fprintf(w, "# TYPE %s %s\n", name, promType(metric))
+		for _, s := range series {
+			io.WriteString(w, name)
+			if len(s.tags) > 0 {
+				keys := make([]string, 0, len(s.tags))
+				for k := range s.tags {
+					keys = append(keys, k)
+				}
+				sort.Strings(keys)
+				io.WriteString(w, "{")
+				for i, k := range keys {
+					if i > 0 {
+						io.WriteString(w, ",")
+					}
+					fmt.Fprintf(w, `%s="%s"`, promLabel(k), promValueEscaper.Replace(s.tags[k]))
+				}
+				io.WriteString(w, "}")
+			}
+			fmt.Fprintf(w, " %s\n", s.value)
+		}
+	}
+}
+
+type byTags []*promSeries
+
+func (b byTags) Len() int           { return len(b) }
+func (b byTags) Less(i, j int) bool { return b[i].tags.String() < b[j].tags.String() }
+func (b byTags) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+
+// promType returns the Prometheus type of metric based on its rate metadata.
+func promType(metric string) string {
+	rate, _ := metadata.Lookup(metric, "rate").(metadata.RateType)
+	switch rate {
+	case metadata.Counter:
+		return "counter"
+	case metadata.Gauge, metadata.Rate:
+		return "gauge"
+	default:
+		return "untyped"
+	}
+}
+
+var (
+	promHelpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	promValueEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+// promName converts an OpenTSDB metric name like os.cpu to a valid Prometheus
+// metric name like os_cpu.
+func promName(s string) string {
+	return promSanitize(s, true)
+}
+
+// promLabel converts a tag key to a valid Prometheus label name.
+func promLabel(s string) string {
+	return promSanitize(s, false)
+}
+
+func promSanitize(s string, colon bool) string {
+	b := []byte(s)
+	for i, c := range b {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '_':
+		case c == ':' && colon:
+		default:
+			b[i] = '_'
+		}
+	}
+	if len(s) > 0 && s[0] >= '0' && s[0] <= '9' {
+		return "_" + string(b)
+	}
+	return string(b)
+}
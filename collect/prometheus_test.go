@@ -0,0 +1,31 @@
+package collect
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/bosun-monitor/scollector/metadata"
+	"github.com/bosun-monitor/scollector/opentsdb"
+)
+
+func TestPrometheus(t *testing.T) {
+	metadata.AddMeta("linux.net.bytes", nil, "rate", metadata.RateType(metadata.Counter), false)
+	metadata.AddMeta("linux.net.bytes", nil, "desc", "Bytes sent or received.", false)
+	p := &promStore{series: make(map[string]*promSeries)}
+	p.observe(&opentsdb.DataPoint{Metric: "linux.net.bytes", Value: "1024", Tags: opentsdb.TagSet{"host": "a", "direction": "in"}})
+	p.observe(&opentsdb.DataPoint{Metric: "linux.net.bytes", Value: 10, Tags: opentsdb.TagSet{"host": "a", "direction": "in"}})
+	p.observe(&opentsdb.DataPoint{Metric: "linux.net.bytes", Value: 20, Tags: opentsdb.TagSet{"host": "a", "direction": "out"}})
+	p.observe(&opentsdb.DataPoint{Metric: "2xx.rate", Value: 1.5, Tags: opentsdb.TagSet{"host": "a", "k.v": `"q"`}})
+	var b bytes.Buffer
+	p.write(&b)
+	const expect = `# TYPE _2xx_rate untyped
+_2xx_rate{host="a",k_v="\"q\""} 1.5
+# HELP linux_net_bytes Bytes sent or received.
+# TYPE linux_net_bytes counter
+linux_net_bytes{direction="in",host="a"} 10
+linux_net_bytes{direction="out",host="a"} 20
+`
+	if b.String() != expect {
+		t.Errorf("got:\n%s\nexpected:\n%s", b.String(), expect)
+	}
+}
@@ -1,131 +1,337 @@
 package collect
 
 import (
-	"bytes"
-	"compress/gzip"
-	"encoding/json"
-	"io/ioutil"
-	"net/http"
+	"fmt"
+	"path/filepath"
+	"sync"
 	"time"
 
 	"github.com/StackExchange/slog"
 	"github.com/bosun-monitor/scollector/opentsdb"
 )
 
+// A sender encodes data points and delivers batches of them to a host.
+type sender interface {
+	// encode converts dp to a single record in the sender's wire format. The
+	// record must not contain a newline.
+	encode(dp *opentsdb.DataPoint) ([]byte, error)
+	// send delivers a batch of records returned by encode. A rejected error
+	// indicates the batch should be dropped instead of retried.
+	send(batch [][]byte) error
+}
+
+// rejected wraps an error for a batch the host refused. Sending it again
+// won't help, so it is dropped.
+type rejected struct {
+	error
+}
+
+// A Destination receives a copy of every data point. Each destination has its
+// own queue, spool, retry state and counters, so a slow or unreachable
+// destination does not hold up the others.
+type Destination struct {
+	// Name identifies the destination in logs and is the dest tag of the
+	// collect self metrics.
+	Name string
+
+	// BatchSize is the maximum number of data points sent at once. Defaults to
+	// the package BatchSize.
+	BatchSize int
+
+	// MaxQueueLen is the maximum number of queued data points. Defaults to the
+	// package MaxQueueLen.
+	MaxQueueLen int
+
+	// SpoolDir, if set, is where data points are spooled when the queue is
+	// full. Defaults to a directory named Name in the package SpoolDir.
+	SpoolDir string
+
+	sender  sender
+	breaker breaker
+
+	sync.Mutex // protects queue, inflight and spool
+	queue      [][]byte
+	inflight   [][]byte // batch taken from queue and being sent
+	spool      *spool
+
+	slock         sync.Mutex // protects sent and dropped
+	sent, dropped int64
+}
+
+func newDestination(name string, s sender) (*Destination, error) {
+	if err := checkClean(name, "destination name"); err != nil {
+		return nil, err
+	}
+	return &Destination{
+		Name:   name,
+		sender: s,
+	}, nil
+}
+
+func (d *Destination) String() string {
+	return d.Name
+}
+
+// start opens the spool and begins sending.
+func (d *Destination) start() error {
+	if d.BatchSize <= 0 {
+		d.BatchSize = BatchSize
+	}
+	if d.MaxQueueLen <= 0 {
+		d.MaxQueueLen = MaxQueueLen
+	}
+	if d.SpoolDir == "" && SpoolDir != "" {
+		d.SpoolDir = filepath.Join(SpoolDir, d.Name)
+	}
+	if d.SpoolDir != "" {
+		s, err := openSpool(d.SpoolDir, MaxSpoolSize)
+		if err != nil {
+			return fmt.Errorf("%s: %v", d, err)
+		}
+		d.spool = s
+	}
+	go d.run()
+	return nil
+}
+
 func queuer() {
-	for dp := range tchan {
-		qlock.Lock()
-		for {
-			if len(queue) > MaxQueueLen {
-				slock.Lock()
-				dropped++
-				slock.Unlock()
-				break
+	for {
+		select {
+		case dp := <-tchan:
+			if prom != nil {
+				prom.observe(dp)
 			}
-			m, err := json.Marshal(dp)
-			if err != nil {
-				slog.Error(err)
-			} else {
-				queue = append(queue, m)
+			for _, d := range dests {
+				d.enqueue(dp)
 			}
-			select {
-			case dp = <-tchan:
-				continue
-			default:
-			}
-			break
+		case c := <-qsync:
+			// Everything received before is now queued.
+			close(c)
 		}
-		qlock.Unlock()
 	}
 }
 
-func send() {
+// enqueue adds dp to the queue, or to the spool if the queue is full or the
+// spool is not yet drained. If there is no room for it, dp is dropped.
+func (d *Destination) enqueue(dp *opentsdb.DataPoint) {
+	d.Lock()
+	defer d.Unlock()
+	if d.spool == nil && len(d.queue) > d.MaxQueueLen {
+		d.recordDropped(1)
+		return
+	}
+	m, err := d.sender.encode(dp)
+	if err != nil {
+		slog.Errorf("%s: %v", d, err)
+		return
+	}
+	if d.spool != nil && (len(d.queue) > d.MaxQueueLen || d.spool.len() > 0) {
+		evicted, err := d.spool.push(m)
+		if err != nil {
+			slog.Errorf("%s: %v", d, err)
+			evicted++
+		}
+		if evicted > 0 {
+			d.recordDropped(evicted)
+		}
+		return
+	}
+	d.queue = append(d.queue, m)
+}
+
+func (d *Destination) run() {
 	for {
-		qlock.Lock()
-		if i := len(queue); i > 0 {
-			if i > BatchSize {
-				i = BatchSize
+		d.Lock()
+		if i := len(d.queue); i > 0 {
+			if i > d.BatchSize {
+				i = d.BatchSize
 			}
-			sending := queue[:i]
-			queue = queue[i:]
+			sending := d.queue[:i]
+			d.queue = d.queue[i:]
+			d.inflight = sending
 			if Debug {
-				slog.Infof("sending: %d, remaining: %d", i, len(queue))
+				slog.Infof("%s: sending: %d, remaining: %d", d, i, len(d.queue))
 			}
-			qlock.Unlock()
-			sendBatch(sending)
+			d.Unlock()
+			d.retry(sending)
+			d.Lock()
+			d.inflight = nil
+			d.Unlock()
+		} else if d.spool != nil && d.spool.len() > 0 {
+			sending, seq, err := d.spool.peek(d.BatchSize)
+			d.Unlock()
+			if err != nil {
+				slog.Errorf("%s: %v", d, err)
+				time.Sleep(d.breaker.failure())
+				continue
+			}
+			if Debug {
+				slog.Infof("%s: sending: %d from spool", d, len(sending))
+			}
+			d.retry(sending)
+			d.Lock()
+			if err := d.spool.commit(seq, len(sending)); err != nil {
+				slog.Errorf("%s: %v", d, err)
+			}
+			d.Unlock()
 		} else {
-			qlock.Unlock()
+			d.Unlock()
 			time.Sleep(time.Second)
 		}
 	}
 }
 
-func sendBatch(batch []json.RawMessage) {
-	if Print {
-		for _, d := range batch {
-			slog.Info(string(d))
+// retry sends batch until it succeeds, backing off exponentially between
+// attempts. The batch is kept intact so data is not reordered.
+func (d *Destination) retry(batch [][]byte) {
+	for {
+		err := d.sendBatch(batch)
+		if err == nil {
+			d.breaker.success()
+			return
 		}
-		recordSent(len(batch))
-		return
-	}
-	var buf bytes.Buffer
-	g := gzip.NewWriter(&buf)
-	if err := json.NewEncoder(g).Encode(batch); err != nil {
-		slog.Error(err)
-		return
+		slog.Errorf("%s: %v", d, err)
+		if _, ok := err.(rejected); ok {
+			d.recordDropped(len(batch))
+			return
+		}
+		wait := d.breaker.failure()
+		slog.Infof("%s: send of %d failed, retrying in %s", d, len(batch), wait)
+		time.Sleep(wait)
+		d.breaker.probe()
 	}
-	if err := g.Close(); err != nil {
-		slog.Error(err)
-		return
+}
+
+func (d *Destination) sendBatch(batch [][]byte) error {
+	if Print {
+		for _, r := range batch {
+			slog.Info(string(r))
+		}
+	} else if err := d.sender.send(batch); err != nil {
+		return err
 	}
-	req, err := http.NewRequest("POST", tsdbURL, &buf)
-	if err != nil {
-		slog.Error(err)
-		return
+	d.recordSent(len(batch))
+	return nil
+}
+
+// drain waits until d has sent its queue or deadline passes. What is still
+// queued or being sent is then moved to the spool, if there is one. It returns
+// the number of data points abandoned.
+func (d *Destination) drain(deadline time.Time) int {
+	for time.Now().Before(deadline) {
+		d.Lock()
+		empty := len(d.queue) == 0 && d.inflight == nil
+		d.Unlock()
+		if empty {
+			return 0
+		}
+		time.Sleep(time.Millisecond * 100)
 	}
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Content-Encoding", "gzip")
-	resp, err := client.Do(req)
-	if err == nil {
-		defer resp.Body.Close()
+	d.Lock()
+	defer d.Unlock()
+	left := make([][]byte, 0, len(d.inflight)+len(d.queue))
+	left = append(left, d.inflight...)
+	left = append(left, d.queue...)
+	d.queue = nil
+	if d.spool == nil {
+		slog.Errorf("%s: abandoned %d data points", d, len(left))
+		return len(left)
 	}
-	// Some problem with connecting to the server; retry later.
-	if err != nil || resp.StatusCode != http.StatusNoContent {
+	abandoned := 0
+	for _, r := range left {
+		evicted, err := d.spool.push(r)
 		if err != nil {
-			slog.Error(err)
-		} else if resp.StatusCode != http.StatusNoContent {
-			slog.Errorln(resp.Status)
-			body, err := ioutil.ReadAll(resp.Body)
-			if err != nil {
-				slog.Error(err)
-			}
-			if len(body) > 0 {
-				slog.Error(string(body))
-			}
-		}
-		restored := 0
-		for _, msg := range batch {
-			var dp opentsdb.DataPoint
-			if err := json.Unmarshal(msg, &dp); err != nil {
-				slog.Error(err)
-				continue
-			}
-			restored++
-			tchan <- &dp
+			slog.Errorf("%s: %v", d, err)
+			evicted++
 		}
-		d := time.Second * 5
-		slog.Infof("restored %d, sleeping %s", restored, d)
-		time.Sleep(d)
-		return
+		abandoned += evicted
 	}
-	recordSent(len(batch))
+	slog.Infof("%s: spooled %d data points", d, len(left))
+	if abandoned > 0 {
+		slog.Errorf("%s: abandoned %d data points", d, abandoned)
+	}
+	return abandoned
 }
 
-func recordSent(num int) {
+func (d *Destination) recordSent(num int) {
 	if Debug {
-		slog.Infoln("sent", num)
+		slog.Infoln(d, "sent", num)
+	}
+	d.slock.Lock()
+	d.sent += int64(num)
+	d.slock.Unlock()
+}
+
+func (d *Destination) recordDropped(num int) {
+	d.slock.Lock()
+	d.dropped += int64(num)
+	d.slock.Unlock()
+}
+
+// DestinationStatus is a snapshot of the state of a Destination.
+type DestinationStatus struct {
+	Name    string `json:"name"`
+	Queued  int    `json:"queued"`
+	Spooled int    `json:"spooled"`
+	Sent    int64  `json:"sent"`
+	Dropped int64  `json:"dropped"`
+	// Circuit is the circuit breaker state: closed, half-open or open.
+	Circuit string `json:"circuit"`
+}
+
+// Status returns the current queue length and counters of d.
+func (d *Destination) Status() DestinationStatus {
+	st := DestinationStatus{Name: d.Name}
+	d.Lock()
+	st.Queued = len(d.queue) + len(d.inflight)
+	if d.spool != nil {
+		st.Spooled = d.spool.len()
+	}
+	d.Unlock()
+	d.slock.Lock()
+	st.Sent, st.Dropped = d.sent, d.dropped
+	d.slock.Unlock()
+	switch d.breaker.current() {
+	case circuitClosed:
+		st.Circuit = "closed"
+	case circuitHalfOpen:
+		st.Circuit = "half-open"
+	case circuitOpen:
+		st.Circuit = "open"
+	}
+	return st
+}
+
+// initStats sets up the collect self metrics of d.
+func (d *Destination) initStats() {
+	ts := opentsdb.TagSet{"dest": d.Name}
+	Set("collect.dropped", ts, func() (i interface{}) {
+		d.slock.Lock()
+		i = d.dropped
+		d.slock.Unlock()
+		return
+	})
+	Set("collect.sent", ts, func() (i interface{}) {
+		d.slock.Lock()
+		i = d.sent
+		d.slock.Unlock()
+		return
+	})
+	Set("collect.queued", ts, func() (i interface{}) {
+		d.Lock()
+		i = len(d.queue)
+		d.Unlock()
+		return
+	})
+	if d.spool != nil {
+		Set("collect.spooled", ts, func() (i interface{}) {
+			d.Lock()
+			i = d.spool.len()
+			d.Unlock()
+			return
+		})
 	}
-	slock.Lock()
-	sent += int64(num)
-	slock.Unlock()
+	Set("collect.circuit", ts, func() interface{} {
+		return d.breaker.current()
+	})
 }
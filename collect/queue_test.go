@@ -0,0 +1,123 @@
+package collect
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bosun-monitor/scollector/opentsdb"
+)
+
+func init() {
+	// Set once: destinations of earlier tests keep running.
+	MinBackoff = time.Millisecond
+}
+
+func TestDestinations(t *testing.T) {
+	var mu sync.Mutex
+	received := make(map[string]int)
+	failed := false
+	handler := func(name string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			defer mu.Unlock()
+			// The second destination fails once and must retry the same batch.
+			if name == "b" && !failed {
+				failed = true
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			g, err := gzip.NewReader(r.Body)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			var md opentsdb.MultiDataPoint
+			if err := json.NewDecoder(g).Decode(&md); err != nil {
+				t.Error(err)
+				return
+			}
+			received[name] += len(md)
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}
+	var ds []*Destination
+	for _, name := range []string{"a", "b"} {
+		ts := httptest.NewServer(handler(name))
+		defer ts.Close()
+		u, _ := url.Parse(ts.URL)
+		d, err := NewOpenTSDB(u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := d.start(); err != nil {
+			t.Fatal(err)
+		}
+		ds = append(ds, d)
+	}
+	for i := 0; i < 10; i++ {
+		dp := &opentsdb.DataPoint{Metric: "test.metric", Timestamp: 1, Value: i, Tags: opentsdb.TagSet{"host": "h"}}
+		for _, d := range ds {
+			d.enqueue(dp)
+		}
+	}
+	deadline := time.Now().Add(time.Second * 10)
+	for time.Now().Before(deadline) {
+		mu.Lock()
+		done := received["a"] == 10 && received["b"] == 10
+		mu.Unlock()
+		if done {
+			return
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	t.Fatalf("expected 10 data points at each destination, got %v", received)
+}
+
+func TestDrain(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+	u, _ := url.Parse(ts.URL)
+	dir, err := ioutil.TempDir("", "drain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, spoolDir := range []string{"", dir} {
+		d, err := NewOpenTSDB(u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		d.BatchSize = 2
+		d.SpoolDir = spoolDir
+		if err := d.start(); err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 5; i++ {
+			d.enqueue(&opentsdb.DataPoint{Metric: "test.metric", Timestamp: 1, Value: i, Tags: opentsdb.TagSet{"host": "h"}})
+		}
+		abandoned := d.drain(time.Now().Add(time.Millisecond * 200))
+		if spoolDir == "" {
+			// Both the batch being retried and the rest of the queue are lost.
+			if abandoned != 5 {
+				t.Errorf("expected 5 abandoned, got %d", abandoned)
+			}
+			continue
+		}
+		d.Lock()
+		spooled := d.spool.len()
+		d.Unlock()
+		if abandoned != 0 || spooled != 5 {
+			t.Errorf("expected 5 spooled, got %d spooled and %d abandoned", spooled, abandoned)
+		}
+	}
+}
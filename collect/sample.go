@@ -0,0 +1,96 @@
+package collect
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+
+	"github.com/bosun-monitor/scollector/opentsdb"
+)
+
+// sampleMetric summarizes the values given to Sample for one series during a
+// sending interval. Count, min, max and mean are exact. Percentiles are
+// computed from a reservoir of at most MaxSamples values.
+type sampleMetric struct {
+	metric        string
+	ts            opentsdb.TagSet
+	count         int64
+	min, max, sum float64
+	values        []float64
+}
+
+// Sample records a value, such as a request latency, whose distribution is
+// of interest. At each sending interval, metric.count, .min, .max, .mean,
+// .median, .p95 and .p99 are sent for the values recorded since the previous
+// interval. Nothing is sent for an interval without values.
+func Sample(metric string, ts opentsdb.TagSet, v float64) error {
+	if err := check(metric, &ts); err != nil {
+		return err
+	}
+	tss := metric + ts.String()
+	mlock.Lock()
+	s := samples[tss]
+	if s == nil {
+		s = &sampleMetric{
+			metric: metric,
+			ts:     ts.Copy(),
+		}
+		samples[tss] = s
+	}
+	s.add(v)
+	mlock.Unlock()
+	return nil
+}
+
+func (s *sampleMetric) add(v float64) {
+	s.count++
+	if s.count == 1 || v < s.min {
+		s.min = v
+	}
+	if s.count == 1 || v > s.max {
+		s.max = v
+	}
+	s.sum += v
+	if len(s.values) < MaxSamples {
+		s.values = append(s.values, v)
+	} else if i := rand.Int63n(s.count); i < int64(len(s.values)) {
+		// Reservoir sampling: each value is kept with equal probability.
+		s.values[i] = v
+	}
+}
+
+func (s *sampleMetric) dataPoints(now int64) []*opentsdb.DataPoint {
+	sort.Float64s(s.values)
+	stats := []struct {
+		suffix string
+		value  interface{}
+	}{
+		{".count", s.count},
+		{".min", s.min},
+		{".max", s.max},
+		{".mean", s.sum / float64(s.count)},
+		{".median", Percentile(s.values, .5)},
+		{".p95", Percentile(s.values, .95)},
+		{".p99", Percentile(s.values, .99)},
+	}
+	dps := make([]*opentsdb.DataPoint, len(stats))
+	for i, st := range stats {
+		dps[i] = &opentsdb.DataPoint{
+			Metric:    metricRoot + s.metric + st.suffix,
+			Timestamp: now,
+			Value:     st.value,
+			Tags:      s.ts,
+		}
+	}
+	return dps
+}
+
+// Percentile returns the p (0 to 1) percentile of the sorted values using the
+// nearest rank.
+func Percentile(sorted []float64, p float64) float64 {
+	i := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return sorted[i]
+}
@@ -0,0 +1,56 @@
+package collect
+
+import "testing"
+
+func TestSample(t *testing.T) {
+	osHostname = "test"
+	for i := 1000; i > 0; i-- {
+		if err := Sample("latency", nil, float64(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := Sample("bad metric", nil, 1); err == nil {
+		t.Error("expected error")
+	}
+	mlock.Lock()
+	s := samples["latency{host=test}"]
+	delete(samples, "latency{host=test}")
+	mlock.Unlock()
+	if s == nil {
+		t.Fatal("no sample recorded")
+	}
+	got := make(map[string]interface{})
+	for _, dp := range s.dataPoints(1) {
+		got[dp.Metric] = dp.Value
+	}
+	for k, v := range map[string]interface{}{
+		"latency.count":  int64(1000),
+		"latency.min":    1.0,
+		"latency.max":    1000.0,
+		"latency.mean":   500.5,
+		"latency.median": 500.0,
+		"latency.p95":    950.0,
+		"latency.p99":    990.0,
+	} {
+		if got[k] != v {
+			t.Errorf("%s: got %v, expected %v", k, got[k], v)
+		}
+	}
+}
+
+func TestSampleReservoir(t *testing.T) {
+	s := &sampleMetric{}
+	for i := 0; i < MaxSamples*10; i++ {
+		s.add(float64(i % 100))
+	}
+	if len(s.values) != MaxSamples {
+		t.Fatalf("reservoir has %d values, expected %d", len(s.values), MaxSamples)
+	}
+	dps := s.dataPoints(1)
+	if dps[0].Value != int64(MaxSamples*10) {
+		t.Errorf("count: got %v", dps[0].Value)
+	}
+	if m := dps[4].Value.(float64); m < 40 || m > 60 {
+		t.Errorf("median of uniform 0-99 values is %v", m)
+	}
+}
@@ -0,0 +1,268 @@
+package collect
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const spoolExt = ".spool"
+
+// spool is an on-disk FIFO of encoded data points. Records are appended one per
+// line to segment files named by an increasing sequence number. When the total
+// size exceeds max, the oldest segments are removed. A segment is deleted only
+// once all of its records have been committed, so records from a partially sent
+// segment are sent again after a restart. The segment being sent is never
+// evicted; newer ones are instead.
+//
+// spool is not safe for concurrent use; callers hold the lock of the
+// Destination that owns it.
+type spool struct {
+	dir     string
+	max     int64
+	segSize int64
+
+	segs  []*segment // oldest first
+	w     *os.File   // open for appending to the newest segment
+	size  int64
+	count int
+
+	// Records of the oldest segment, loaded by peek.
+	rbuf [][]byte
+	roff int
+	// reading is set from peek to commit, while records of the oldest
+	// segment are being sent. That segment is then not evicted.
+	reading bool
+}
+
+type segment struct {
+	seq   uint64
+	size  int64
+	count int
+}
+
+func (s *segment) name() string {
+	return fmt.Sprintf("%020d%s", s.seq, spoolExt)
+}
+
+// openSpool opens (creating if needed) the spool in dir, limited to max bytes.
+func openSpool(dir string, max int64) (*spool, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	d, err := os.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	names, err := d.Readdirnames(0)
+	d.Close()
+	if err != nil {
+		return nil, err
+	}
+	s := &spool{
+		dir:     dir,
+		max:     max,
+		segSize: max / 16,
+	}
+	if s.segSize > 4<<20 {
+		s.segSize = 4 << 20
+	}
+	for _, n := range names {
+		if !strings.HasSuffix(n, spoolExt) {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(n, spoolExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		seg := &segment{seq: seq}
+		recs, err := s.read(seg)
+		if err != nil {
+			return nil, err
+		}
+		fi, err := os.Stat(s.path(seg))
+		if err != nil {
+			return nil, err
+		}
+		seg.size = fi.Size()
+		seg.count = len(recs)
+		s.segs = append(s.segs, seg)
+		s.size += seg.size
+		s.count += seg.count
+	}
+	sort.Sort(bySeq(s.segs))
+	return s, nil
+}
+
+type bySeq []*segment
+
+func (b bySeq) Len() int           { return len(b) }
+func (b bySeq) Less(i, j int) bool { return b[i].seq < b[j].seq }
+func (b bySeq) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+
+func (s *spool) path(seg *segment) string {
+	return filepath.Join(s.dir, seg.name())
+}
+
+// read returns all complete records of seg. A trailing record without a
+// newline (from an interrupted write) is ignored.
+func (s *spool) read(seg *segment) ([][]byte, error) {
+	f, err := os.Open(s.path(seg))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var recs [][]byte
+	r := bufio.NewReader(f)
+	for {
+		b, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			return recs, nil
+		} else if err != nil {
+			return nil, err
+		}
+		if len(b) > 1 {
+			recs = append(recs, b[:len(b)-1])
+		}
+	}
+}
+
+// len returns the number of records in the spool.
+func (s *spool) len() int {
+	return s.count
+}
+
+// push appends rec to the spool. It returns the number of records evicted to
+// stay below the size limit.
+func (s *spool) push(rec []byte) (evicted int, err error) {
+	if s.w == nil || s.segs[len(s.segs)-1].size >= s.segSize {
+		if err := s.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	b := make([]byte, len(rec)+1)
+	copy(b, rec)
+	b[len(rec)] = '\n'
+	n, err := s.w.Write(b)
+	seg := s.segs[len(s.segs)-1]
+	seg.size += int64(n)
+	s.size += int64(n)
+	if err != nil {
+		return 0, err
+	}
+	seg.count++
+	s.count++
+	for s.size > s.max {
+		// Neither the segment being written nor the one being sent may be
+		// removed.
+		i := 0
+		if s.reading {
+			i = 1
+		}
+		if i >= len(s.segs)-1 {
+			break
+		}
+		n, err := s.evict(i)
+		evicted += n
+		if err != nil {
+			return evicted, err
+		}
+	}
+	return evicted, nil
+}
+
+// rotate closes the current segment and starts a new one.
+func (s *spool) rotate() error {
+	if s.w != nil {
+		if err := s.w.Close(); err != nil {
+			return err
+		}
+		s.w = nil
+	}
+	seg := &segment{seq: 1}
+	if len(s.segs) > 0 {
+		seg.seq = s.segs[len(s.segs)-1].seq + 1
+	}
+	f, err := os.OpenFile(s.path(seg), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	s.w = f
+	s.segs = append(s.segs, seg)
+	return nil
+}
+
+// evict removes the i-th oldest segment and returns the number of unsent
+// records it held.
+func (s *spool) evict(i int) (int, error) {
+	seg := s.segs[i]
+	n := seg.count
+	if i == 0 {
+		s.rbuf = nil
+		s.roff = 0
+	}
+	s.segs = append(s.segs[:i], s.segs[i+1:]...)
+	s.size -= seg.size
+	s.count -= n
+	return n, os.Remove(s.path(seg))
+}
+
+// peek returns up to n of the oldest records without removing them, and the
+// sequence number of their segment, to be given to commit.
+func (s *spool) peek(n int) ([][]byte, uint64, error) {
+	for s.rbuf == nil {
+		if len(s.segs) == 0 {
+			return nil, 0, nil
+		}
+		if len(s.segs) == 1 && s.w != nil {
+			// Stop appending to the segment we are about to read.
+			if err := s.w.Close(); err != nil {
+				return nil, 0, err
+			}
+			s.w = nil
+		}
+		recs, err := s.read(s.segs[0])
+		if err != nil {
+			return nil, 0, err
+		}
+		// Account for anything lost from an interrupted write.
+		s.count -= s.segs[0].count - len(recs)
+		s.segs[0].count = len(recs)
+		if len(recs) == 0 {
+			if _, err := s.evict(0); err != nil {
+				return nil, 0, err
+			}
+			continue
+		}
+		s.rbuf = recs
+		s.roff = 0
+	}
+	recs := s.rbuf[s.roff:]
+	if len(recs) > n {
+		recs = recs[:n]
+	}
+	s.reading = true
+	return recs, s.segs[0].seq, nil
+}
+
+// commit removes the n oldest records, which must have been returned by the
+// last peek along with seq. It does nothing if their segment is gone.
+func (s *spool) commit(seq uint64, n int) error {
+	s.reading = false
+	if len(s.segs) == 0 || s.segs[0].seq != seq || s.rbuf == nil {
+		return nil
+	}
+	s.roff += n
+	s.count -= n
+	s.segs[0].count -= n
+	if s.roff < len(s.rbuf) {
+		return nil
+	}
+	_, err := s.evict(0)
+	return err
+}
@@ -0,0 +1,158 @@
+package collect
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestSpool(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s, err := openSpool(dir, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		if _, err := s.push([]byte(fmt.Sprint(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	recs, seq, err := s.peek(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recs) != 4 || string(recs[0]) != "0" || string(recs[3]) != "3" {
+		t.Fatalf("unexpected peek: %q", recs)
+	}
+	if err := s.commit(seq, 4); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.push([]byte("10")); err != nil {
+		t.Fatal(err)
+	}
+	if s.len() != 7 {
+		t.Errorf("expected 7 records, got %d", s.len())
+	}
+
+	// Reopening sends the partially committed segment again.
+	s, err = openSpool(dir, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.len() != 11 {
+		t.Errorf("expected 11 records after reopen, got %d", s.len())
+	}
+	var got []string
+	for s.len() > 0 {
+		recs, seq, err := s.peek(3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, r := range recs {
+			got = append(got, string(r))
+		}
+		if err := s.commit(seq, len(recs)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if fmt.Sprint(got) != "[0 1 2 3 4 5 6 7 8 9 10]" {
+		t.Errorf("unexpected order: %v", got)
+	}
+}
+
+func TestSpoolEvict(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s, err := openSpool(dir, 160)
+	if err != nil {
+		t.Fatal(err)
+	}
+	evicted := 0
+	for i := 0; i < 100; i++ {
+		n, err := s.push([]byte(fmt.Sprintf("%03d", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		evicted += n
+	}
+	if s.size > 160 {
+		t.Errorf("spool size %d exceeds limit", s.size)
+	}
+	if evicted+s.len() != 100 {
+		t.Errorf("evicted %d and kept %d, expected 100 total", evicted, s.len())
+	}
+	recs, _, err := s.peek(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(recs[0]) != fmt.Sprintf("%03d", evicted) {
+		t.Errorf("expected oldest remaining record %03d, got %s", evicted, recs[0])
+	}
+}
+
+// TestSpoolPushWhileSending pushes enough to evict segments between a peek and
+// its commit, as the queue does while a batch from the spool is being sent.
+func TestSpoolPushWhileSending(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s, err := openSpool(dir, 160)
+	if err != nil {
+		t.Fatal(err)
+	}
+	next := 0
+	push := func(n int) (evicted int) {
+		for i := 0; i < n; i++ {
+			e, err := s.push([]byte(fmt.Sprintf("%03d", next)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			evicted += e
+			next++
+		}
+		return evicted
+	}
+	evicted := push(30)
+	var got []string
+	for s.len() > 0 {
+		recs, seq, err := s.peek(2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, r := range recs {
+			got = append(got, string(r))
+		}
+		if next < 200 {
+			evicted += push(20)
+		}
+		if err := s.commit(seq, len(recs)); err != nil {
+			t.Fatal(err)
+		}
+		if s.count < 0 {
+			t.Fatalf("negative count %d", s.count)
+		}
+	}
+	if len(got)+evicted != next {
+		t.Errorf("sent %d and evicted %d, expected %d total", len(got), evicted, next)
+	}
+	seen := make(map[string]bool)
+	for i, r := range got {
+		if seen[r] {
+			t.Fatalf("%s sent twice", r)
+		}
+		seen[r] = true
+		if i > 0 && r < got[i-1] {
+			t.Fatalf("%s sent after %s", r, got[i-1])
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package collect
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/bosun-monitor/scollector/opentsdb"
+)
+
+// tsdbSender sends gzipped JSON to the OpenTSDB /api/put endpoint.
+type tsdbSender struct {
+	url string
+}
+
+// NewOpenTSDB returns a destination that sends to the /api/put endpoint of an
+// OpenTSDB or bosun host.
+func NewOpenTSDB(host *url.URL) (*Destination, error) {
+	u, err := host.Parse("/api/put")
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasPrefix(u.Host, ":") {
+		u.Host = "localhost" + u.Host
+	}
+	return newDestination(opentsdb.MustReplace(u.Host, "_"), &tsdbSender{url: u.String()})
+}
+
+func (t *tsdbSender) encode(dp *opentsdb.DataPoint) ([]byte, error) {
+	return json.Marshal(dp)
+}
+
+func (t *tsdbSender) send(batch [][]byte) error {
+	var buf bytes.Buffer
+	g := gzip.NewWriter(&buf)
+	g.Write([]byte{'['})
+	for i, r := range batch {
+		if i > 0 {
+			g.Write([]byte{','})
+		}
+		g.Write(r)
+	}
+	g.Write([]byte{']'})
+	if err := g.Close(); err != nil {
+		return rejected{err}
+	}
+	req, err := http.NewRequest("POST", t.url, &buf)
+	if err != nil {
+		return rejected{err}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-Encoding", "gzip")
+	resp, err := client.Do(req)
+	// Some problem with connecting to the server; retry later.
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	return responseError(resp)
+}
+
+// responseError returns an error describing an unexpected HTTP response. 4xx
+// responses other than timeouts mean the server refused the data itself, so
+// they are rejected.
+func responseError(resp *http.Response) error {
+	err := fmt.Errorf("unexpected response: %s", resp.Status)
+	if body, _ := ioutil.ReadAll(resp.Body); len(body) > 0 {
+		err = fmt.Errorf("unexpected response: %s: %s", resp.Status, bytes.TrimSpace(body))
+	}
+	if resp.StatusCode >= 400 && resp.StatusCode < 500 &&
+		resp.StatusCode != http.StatusRequestTimeout && resp.StatusCode != 429 {
+		return rejected{err}
+	}
+	return err
+}
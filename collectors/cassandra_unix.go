@@ -7,7 +7,6 @@ import (
 
 	"github.com/bosun-monitor/scollector/metadata"
 	"github.com/bosun-monitor/scollector/opentsdb"
-	"github.com/bosun-monitor/scollector/util"
 )
 
 func init() {
@@ -17,7 +16,7 @@ func init() {
 func c_nodestats_cfstats_linux() (opentsdb.MultiDataPoint, error) {
 	var md opentsdb.MultiDataPoint
 	var keyspace, table string
-	util.ReadCommand(func(line string) error {
+	readCommand(func(line string) error {
 		fields := strings.Split(strings.TrimSpace(line), ": ")
 		if len(fields) != 2 {
 			return nil
@@ -0,0 +1,325 @@
+package collectors
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/bosun-monitor/scollector/metadata"
+	"github.com/bosun-monitor/scollector/opentsdb"
+)
+
+func init() {
+	collectors = append(collectors, &IntervalCollector{F: c_cgroup_linux, Enable: cgroupEnable})
+}
+
+// cgroupV1Hierarchies are the cgroup v1 controller mounts read, relative to
+// the cgroup root. A controller may be mounted alone or with others.
+var cgroupV1Hierarchies = []string{"cpu,cpuacct", "cpuacct,cpu", "cpu", "cpuacct", "memory", "blkio", "pids"}
+
+// cgroupContainerRE matches the container ID in the name of a cgroup created
+// by docker, containerd, CRI-O, podman or kubernetes, such as
+// docker-<id>.scope or /docker/<id>.
+var cgroupContainerRE = regexp.MustCompile(`(?:^|[-:])([0-9a-f]{64})(?:\.scope)?$`)
+
+// cgroupUnlimited is the smallest memory limit considered unlimited. cgroup v1
+// reports no limit as the largest page aligned int64.
+const cgroupUnlimited = 1 << 62
+
+func cgroupRoot() string {
+	return sysPath("fs/cgroup")
+}
+
+func cgroupEnable() bool {
+	_, err := os.Stat(cgroupRoot())
+	return err == nil
+}
+
+// c_cgroup_linux reports the resource usage of every cgroup but the root
+// one, from the unified cgroup v2 hierarchy if mounted, else from the v1
+// controller hierarchies.
+func c_cgroup_linux() (opentsdb.MultiDataPoint, error) {
+	var md opentsdb.MultiDataPoint
+	root := cgroupRoot()
+	if _, err := os.Stat(filepath.Join(root, "cgroup.controllers")); err == nil {
+		err := walkCgroups(root, func(dir string, ts opentsdb.TagSet) {
+			cgroupV2CPU(&md, dir, ts)
+			cgroupV2Memory(&md, dir, ts)
+			cgroupV2IO(&md, dir, ts)
+			cgroupPids(&md, dir, ts)
+			cgroupPressure(&md, dir, ts)
+		})
+		return md, err
+	}
+	seen := make(map[string]bool)
+	var walkErr error
+	for _, h := range cgroupV1Hierarchies {
+		dir, err := filepath.EvalSymlinks(filepath.Join(root, h))
+		if err != nil || seen[dir] {
+			continue
+		}
+		seen[dir] = true
+		if err := walkCgroups(dir, func(dir string, ts opentsdb.TagSet) {
+			cgroupV1CPU(&md, dir, ts)
+			cgroupV1Memory(&md, dir, ts)
+			cgroupV1Blkio(&md, dir, ts)
+			cgroupPids(&md, dir, ts)
+		}); err != nil && walkErr == nil {
+			walkErr = err
+		}
+	}
+	return md, walkErr
+}
+
+// walkCgroups calls f for every cgroup below root, down to CgroupDepth levels,
+// with its tags.
+func walkCgroups(root string, f func(dir string, ts opentsdb.TagSet)) error {
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			// The cgroup may have been removed since its parent was read.
+			if os.IsNotExist(err) && path != root {
+				return nil
+			}
+			return err
+		}
+		if !info.IsDir() || path == root {
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		f(path, cgroupTags(rel))
+		if CgroupDepth > 0 && strings.Count(rel, "/")+1 >= CgroupDepth {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+}
+
+// cgroupTags returns the tags of the cgroup at path, relative to its
+// hierarchy root: the path with characters not allowed in tags replaced by _,
+// and the short ID of the container it belongs to, if any.
+func cgroupTags(path string) opentsdb.TagSet {
+	ts := opentsdb.TagSet{"cgroup": opentsdb.MustReplace(path, "_")}
+	elems := strings.Split(path, "/")
+	for i := len(elems) - 1; i >= 0; i-- {
+		if m := cgroupContainerRE.FindStringSubmatch(elems[i]); m != nil {
+			ts["container"] = m[1][:12]
+			break
+		}
+	}
+	return ts
+}
+
+// cgroupValue returns the number in the named file of dir. ok is false if the
+// file does not exist or does not contain a number, as when a limit is "max".
+func cgroupValue(dir, name string) (v int64, ok bool) {
+	readLine(filepath.Join(dir, name), func(s string) error {
+		i, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+		v, ok = i, err == nil
+		return nil
+	})
+	return
+}
+
+// cgroupKeyed returns the "key value" pairs of the named file of dir.
+func cgroupKeyed(dir, name string) map[string]int64 {
+	m := make(map[string]int64)
+	readLine(filepath.Join(dir, name), func(s string) error {
+		f := strings.Fields(s)
+		if len(f) != 2 {
+			return nil
+		}
+		if v, err := strconv.ParseInt(f[1], 10, 64); err == nil {
+			m[f[0]] = v
+		}
+		return nil
+	})
+	return m
+}
+
+const (
+	descCgroupCPUUsage     = "Total CPU time consumed by tasks in the cgroup."
+	descCgroupCPUPeriods   = "Number of enforcement periods elapsed while the cgroup had a CPU quota."
+	descCgroupCPUThrottled = "Number of enforcement periods in which the cgroup was throttled for using its whole CPU quota."
+	descCgroupCPUThrTime   = "Total time the tasks of the cgroup were throttled."
+	descCgroupMemUsage     = "Memory used by the cgroup, including page cache."
+	descCgroupMemLimit     = "Memory limit of the cgroup. Not sent if unlimited."
+	descCgroupMemRSS       = "Anonymous memory used by the cgroup."
+	descCgroupMemCache     = "Page cache memory used by the cgroup."
+	descCgroupMemOOMKills  = "Number of processes of the cgroup killed by the OOM killer."
+	descCgroupIOBytes      = "Bytes read from or written to block devices by the cgroup."
+	descCgroupIOOps        = "Read or write operations on block devices by the cgroup."
+	descCgroupPids         = "Number of tasks in the cgroup."
+	descCgroupPidsMax      = "Maximum number of tasks in the cgroup. Not sent if unlimited."
+)
+
+func cgroupV2CPU(md *opentsdb.MultiDataPoint, dir string, ts opentsdb.TagSet) {
+	st := cgroupKeyed(dir, "cpu.stat")
+	for _, s := range []struct{ key, metric, desc string }{
+		{"usage_usec", "usage", descCgroupCPUUsage},
+		{"user_usec", "user", ""},
+		{"system_usec", "system", ""},
+		{"throttled_usec", "throttled_time", descCgroupCPUThrTime},
+	} {
+		if v, ok := st[s.key]; ok {
+			Add(md, "linux.cgroup.cpu."+s.metric, float64(v)/1e6, ts, metadata.Counter, metadata.Second, s.desc)
+		}
+	}
+	if v, ok := st["nr_periods"]; ok {
+		Add(md, "linux.cgroup.cpu.periods", v, ts, metadata.Counter, metadata.Count, descCgroupCPUPeriods)
+	}
+	if v, ok := st["nr_throttled"]; ok {
+		Add(md, "linux.cgroup.cpu.throttled", v, ts, metadata.Counter, metadata.Count, descCgroupCPUThrottled)
+	}
+}
+
+func cgroupV1CPU(md *opentsdb.MultiDataPoint, dir string, ts opentsdb.TagSet) {
+	if v, ok := cgroupValue(dir, "cpuacct.usage"); ok {
+		Add(md, "linux.cgroup.cpu.usage", float64(v)/1e9, ts, metadata.Counter, metadata.Second, descCgroupCPUUsage)
+	}
+	// cpuacct.stat is in USER_HZ, which is 100 on all supported platforms.
+	st := cgroupKeyed(dir, "cpuacct.stat")
+	for _, k := range []string{"user", "system"} {
+		if v, ok := st[k]; ok {
+			Add(md, "linux.cgroup.cpu."+k, float64(v)/100, ts, metadata.Counter, metadata.Second, "")
+		}
+	}
+	st = cgroupKeyed(dir, "cpu.stat")
+	if v, ok := st["nr_periods"]; ok {
+		Add(md, "linux.cgroup.cpu.periods", v, ts, metadata.Counter, metadata.Count, descCgroupCPUPeriods)
+	}
+	if v, ok := st["nr_throttled"]; ok {
+		Add(md, "linux.cgroup.cpu.throttled", v, ts, metadata.Counter, metadata.Count, descCgroupCPUThrottled)
+	}
+	if v, ok := st["throttled_time"]; ok {
+		Add(md, "linux.cgroup.cpu.throttled_time", float64(v)/1e9, ts, metadata.Counter, metadata.Second, descCgroupCPUThrTime)
+	}
+}
+
+func cgroupV2Memory(md *opentsdb.MultiDataPoint, dir string, ts opentsdb.TagSet) {
+	if v, ok := cgroupValue(dir, "memory.current"); ok {
+		Add(md, "linux.cgroup.mem.usage", v, ts, metadata.Gauge, metadata.Bytes, descCgroupMemUsage)
+	}
+	if v, ok := cgroupValue(dir, "memory.max"); ok {
+		Add(md, "linux.cgroup.mem.limit", v, ts, metadata.Gauge, metadata.Bytes, descCgroupMemLimit)
+	}
+	st := cgroupKeyed(dir, "memory.stat")
+	if v, ok := st["anon"]; ok {
+		Add(md, "linux.cgroup.mem.rss", v, ts, metadata.Gauge, metadata.Bytes, descCgroupMemRSS)
+	}
+	if v, ok := st["file"]; ok {
+		Add(md, "linux.cgroup.mem.cache", v, ts, metadata.Gauge, metadata.Bytes, descCgroupMemCache)
+	}
+	if v, ok := cgroupKeyed(dir, "memory.events")["oom_kill"]; ok {
+		Add(md, "linux.cgroup.mem.oom_kills", v, ts, metadata.Counter, metadata.Process, descCgroupMemOOMKills)
+	}
+}
+
+func cgroupV1Memory(md *opentsdb.MultiDataPoint, dir string, ts opentsdb.TagSet) {
+	if v, ok := cgroupValue(dir, "memory.usage_in_bytes"); ok {
+		Add(md, "linux.cgroup.mem.usage", v, ts, metadata.Gauge, metadata.Bytes, descCgroupMemUsage)
+	}
+	if v, ok := cgroupValue(dir, "memory.limit_in_bytes"); ok && v < cgroupUnlimited {
+		Add(md, "linux.cgroup.mem.limit", v, ts, metadata.Gauge, metadata.Bytes, descCgroupMemLimit)
+	}
+	st := cgroupKeyed(dir, "memory.stat")
+	if v, ok := st["rss"]; ok {
+		Add(md, "linux.cgroup.mem.rss", v, ts, metadata.Gauge, metadata.Bytes, descCgroupMemRSS)
+	}
+	if v, ok := st["cache"]; ok {
+		Add(md, "linux.cgroup.mem.cache", v, ts, metadata.Gauge, metadata.Bytes, descCgroupMemCache)
+	}
+	// Only kernels since 4.13 count OOM kills.
+	if v, ok := cgroupKeyed(dir, "memory.oom_control")["oom_kill"]; ok {
+		Add(md, "linux.cgroup.mem.oom_kills", v, ts, metadata.Counter, metadata.Process, descCgroupMemOOMKills)
+	}
+}
+
+var cgroupIOStatFields = map[string]struct {
+	metric, direction string
+	unit              metadata.Unit
+	desc              string
+}{
+	"rbytes": {"bytes", "read", metadata.Bytes, descCgroupIOBytes},
+	"wbytes": {"bytes", "write", metadata.Bytes, descCgroupIOBytes},
+	"rios":   {"ops", "read", metadata.Operation, descCgroupIOOps},
+	"wios":   {"ops", "write", metadata.Operation, descCgroupIOOps},
+}
+
+// cgroupV2IO reads io.stat, whose lines are of the form
+// "8:0 rbytes=1 wbytes=2 rios=3 wios=4 dbytes=0 dios=0".
+func cgroupV2IO(md *opentsdb.MultiDataPoint, dir string, ts opentsdb.TagSet) {
+	readLine(filepath.Join(dir, "io.stat"), func(s string) error {
+		f := strings.Fields(s)
+		if len(f) < 2 {
+			return nil
+		}
+		tags := ts.Copy().Merge(opentsdb.TagSet{"dev": blockDevName(f[0])})
+		for _, kv := range f[1:] {
+			sp := strings.SplitN(kv, "=", 2)
+			if len(sp) != 2 {
+				continue
+			}
+			st, ok := cgroupIOStatFields[sp[0]]
+			if !ok {
+				continue
+			}
+			Add(md, "linux.cgroup.io."+st.metric, sp[1], tags.Copy().Merge(opentsdb.TagSet{"direction": st.direction}), metadata.Counter, st.unit, st.desc)
+		}
+		return nil
+	})
+}
+
+// cgroupV1Blkio reads the blkio throttle statistics, which are kept whatever
+// the I/O scheduler. Their lines are of the form "8:0 Read 4096".
+func cgroupV1Blkio(md *opentsdb.MultiDataPoint, dir string, ts opentsdb.TagSet) {
+	for _, f := range []struct {
+		file, metric string
+		unit         metadata.Unit
+		desc         string
+	}{
+		{"blkio.throttle.io_service_bytes", "bytes", metadata.Bytes, descCgroupIOBytes},
+		{"blkio.throttle.io_serviced", "ops", metadata.Operation, descCgroupIOOps},
+	} {
+		readLine(filepath.Join(dir, f.file), func(s string) error {
+			sp := strings.Fields(s)
+			if len(sp) != 3 {
+				return nil
+			}
+			direction := strings.ToLower(sp[1])
+			if direction != "read" && direction != "write" {
+				return nil
+			}
+			tags := ts.Copy().Merge(opentsdb.TagSet{"dev": blockDevName(sp[0]), "direction": direction})
+			Add(md, "linux.cgroup.io."+f.metric, sp[2], tags, metadata.Counter, f.unit, f.desc)
+			return nil
+		})
+	}
+}
+
+func cgroupPids(md *opentsdb.MultiDataPoint, dir string, ts opentsdb.TagSet) {
+	if v, ok := cgroupValue(dir, "pids.current"); ok {
+		Add(md, "linux.cgroup.pids", v, ts, metadata.Gauge, metadata.Process, descCgroupPids)
+	}
+	if v, ok := cgroupValue(dir, "pids.max"); ok {
+		Add(md, "linux.cgroup.pids_max", v, ts, metadata.Gauge, metadata.Process, descCgroupPidsMax)
+	}
+}
+
+// blockDevName returns the name of the block device numbered majmin, such as
+// "8:0", or majmin with : replaced by _ if it is unknown.
+func blockDevName(majmin string) string {
+	name := strings.Replace(majmin, ":", "_", -1)
+	readLine(sysPath("dev/block", majmin, "uevent"), func(s string) error {
+		if strings.HasPrefix(s, "DEVNAME=") {
+			name = opentsdb.MustReplace(strings.TrimPrefix(s, "DEVNAME="), "_")
+		}
+		return nil
+	})
+	return name
+}
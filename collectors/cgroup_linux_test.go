@@ -0,0 +1,28 @@
+package collectors
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCgroupDepth(t *testing.T) {
+	defer func(sys string, depth int) { SysRoot, CgroupDepth = sys, depth }(SysRoot, CgroupDepth)
+	SysRoot = filepath.Join("testdata", "cgroup_v2_linux", "sys")
+	CgroupDepth = 1
+	md, err := c_cgroup_linux()
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := make(map[string]bool)
+	for _, dp := range md {
+		cg := dp.Tags["cgroup"]
+		if strings.Contains(cg, "/") {
+			t.Fatalf("cgroup %s reported beyond depth 1", cg)
+		}
+		seen[cg] = true
+	}
+	if !seen["user.slice"] {
+		t.Errorf("expected the top level cgroup, got %v", seen)
+	}
+}
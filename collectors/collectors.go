@@ -2,13 +2,18 @@ package collectors
 
 import (
 	"bufio"
+	"bytes"
+	"context"
 	"os"
+	"path/filepath"
 	"strings"
 	"sync"
 	"time"
 	"unicode"
 	"unicode/utf8"
 
+	"github.com/StackExchange/slog"
+	"github.com/bosun-monitor/scollector/collect"
 	"github.com/bosun-monitor/scollector/metadata"
 	"github.com/bosun-monitor/scollector/opentsdb"
 	"github.com/bosun-monitor/scollector/util"
@@ -16,8 +21,10 @@ import (
 
 var collectors []Collector
 
+// A Collector sends data points until the context given to Run is canceled.
+// It then finishes its current collection, if any, and returns.
 type Collector interface {
-	Run(chan<- *opentsdb.DataPoint)
+	Run(ctx context.Context, dpchan chan<- *opentsdb.DataPoint)
 	Name() string
 	Init()
 }
@@ -58,8 +65,42 @@ var (
 	// specified.
 	DefaultFreq = time.Second * 15
 
+	// DefaultTimeout is how long an IntervalCollector may take to collect
+	// if no timeout is specified. Late results are discarded.
+	DefaultTimeout = time.Minute * 2
+
+	// StopTimeout is how long a reload or enable waits for a stopped
+	// collector to return before moving on. The collector is started again
+	// once it does.
+	StopTimeout = time.Second * 10
+
+	// ProcRoot is where the Linux collectors read procfs from. Set it to, for
+	// example, /host/proc to monitor the host from a container that has the
+	// host's /proc mounted there.
+	ProcRoot = "/proc"
+
+	// SysRoot is where the Linux collectors read sysfs from.
+	SysRoot = "/sys"
+
+	// DockerSocket is the unix socket of the Docker Engine API. The Docker
+	// collector is enabled if it exists.
+	DockerSocket = "/var/run/docker.sock"
+
+	// DockerLabels are the container labels the Docker collector adds as tags,
+	// for containers that have them.
+	DockerLabels []string
+
+	// CgroupDepth is how many levels of cgroups below the root the cgroup
+	// collector reports, to bound the number of series. Zero or less means
+	// no limit.
+	CgroupDepth = 4
+
 	timestamp = time.Now().Unix()
 	tlock     sync.Mutex
+
+	// runCommand runs the programs of the collectors. Tests replace it to
+	// return recorded output.
+	runCommand = util.Command
 )
 
 func init() {
@@ -79,6 +120,35 @@ func now() (t int64) {
 	return
 }
 
+// procPath returns the path of the procfs file whose path relative to the
+// procfs root is the joined elem, such as "net/dev".
+func procPath(elem ...string) string {
+	return filepath.Join(ProcRoot, filepath.Join(elem...))
+}
+
+// sysPath returns the path of the sysfs file whose path relative to the sysfs
+// root is the joined elem.
+func sysPath(elem ...string) string {
+	return filepath.Join(SysRoot, filepath.Join(elem...))
+}
+
+// statName returns name, the name of a collector, in a form suitable for the
+// collector tag of self metrics: the package path of collectors named after
+// their function is removed, as is the directory of external collectors.
+func statName(name string) string {
+	name = name[strings.LastIndex(name, "/")+1:]
+	name = strings.TrimPrefix(name, "collectors.")
+	return opentsdb.MustReplace(name, "_")
+}
+
+// countEvent increments the self metric counter metric of the named collector.
+func countEvent(metric, name string) {
+	if collect.DisableDefaultCollectors {
+		return
+	}
+	collect.Add(metric, opentsdb.TagSet{"collector": statName(name)}, 1)
+}
+
 // Search returns all collectors matching the pattern s.
 func Search(s string) []Collector {
 	var r []Collector
@@ -90,16 +160,10 @@ func Search(s string) []Collector {
 	return r
 }
 
-// Runs specified collectors. Use nil for all collectors.
-func Run(cs []Collector) chan *opentsdb.DataPoint {
-	if cs == nil {
-		cs = collectors
-	}
-	ch := make(chan *opentsdb.DataPoint)
-	for _, c := range cs {
-		go c.Run(ch)
-	}
-	return ch
+// Restore sets the registered collectors to cs, as returned by an earlier call
+// to Search. Collectors registered since are removed.
+func Restore(cs []Collector) {
+	collectors = append([]Collector(nil), cs...)
 }
 
 // AddTS is the same as Add but lets you specify the timestamp
@@ -151,6 +215,30 @@ func readLine(fname string, line func(string) error) error {
 	return scanner.Err()
 }
 
+// readCommand is the same as util.ReadCommand, but runs name with runCommand.
+func readCommand(line func(string) error, name string, arg ...string) error {
+	return readCommandTimeout(time.Second*10, line, name, arg...)
+}
+
+// readCommandTimeout is the same as util.ReadCommandTimeout, but runs name
+// with runCommand.
+func readCommandTimeout(timeout time.Duration, line func(string) error, name string, arg ...string) error {
+	b, err := runCommand(timeout, name, arg...)
+	if err != nil {
+		return err
+	}
+	scanner := bufio.NewScanner(bytes.NewBuffer(b))
+	for scanner.Scan() {
+		if err := line(scanner.Text()); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		slog.Infof("%v: %v\n", name, err)
+	}
+	return nil
+}
+
 // IsDigit returns true if s consists of decimal digits.
 func IsDigit(s string) bool {
 	r := strings.NewReader(s)
@@ -1,6 +1,21 @@
 package collectors
 
-import "testing"
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bosun-monitor/scollector/opentsdb"
+	"github.com/bosun-monitor/scollector/util"
+)
 
 func TestIsDigit(t *testing.T) {
 	if IsDigit("1a3") {
@@ -10,3 +25,304 @@ func TestIsDigit(t *testing.T) {
 		t.Error("029: expected true")
 	}
 }
+
+func TestParseTcollectorValue(t *testing.T) {
+	util.Hostname = "test"
+	dp, err := parseTcollectorValue("app.requests 1434055562 42 route=home host=web01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dp.Metric != "app.requests" || dp.Timestamp != 1434055562 || dp.Value != 42.0 {
+		t.Errorf("unexpected data point: %v", dp)
+	}
+	if dp.Tags.String() != "{host=web01,route=home}" {
+		t.Errorf("unexpected tags: %v", dp.Tags)
+	}
+	dp, err = parseTcollectorValue("app.requests 1434055562 42 host=")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dp.Tags) != 0 {
+		t.Errorf("expected no tags, got %v", dp.Tags)
+	}
+	for _, bad := range []string{
+		"app.requests 1434055562",
+		"app.requests now 42",
+		"app.requests 1434055562 x",
+		"app.requests 1434055562 42 route",
+	} {
+		if _, err := parseTcollectorValue(bad); err == nil {
+			t.Errorf("%q: expected error", bad)
+		}
+	}
+	// Invalid metric names are left to the caller.
+	if _, err := parseTcollectorValue("app.requests! 1434055562 42"); err != nil {
+		t.Error(err)
+	}
+	dpchan := make(chan *opentsdb.DataPoint, 1)
+	p := &PutListener{}
+	p.put("put app.requests! 1434055562 42", dpchan)
+	if len(dpchan) != 0 {
+		t.Error("put accepted a bad metric")
+	}
+}
+
+func TestRelay(t *testing.T) {
+	util.Hostname = "test"
+	dpchan := make(chan *opentsdb.DataPoint, 10)
+	r := &RelayListener{dpchan: dpchan}
+	post := func(body string, gz bool) int {
+		var b bytes.Buffer
+		if gz {
+			g := gzip.NewWriter(&b)
+			g.Write([]byte(body))
+			g.Close()
+		} else {
+			b.WriteString(body)
+		}
+		req, _ := http.NewRequest("POST", "/api/put", &b)
+		if gz {
+			req.Header.Set("Content-Encoding", "gzip")
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+	if c := post(`{"metric":"a.b","timestamp":1434055562,"value":9007199254740993,"tags":{"k":"v"}}`, false); c != 204 {
+		t.Fatalf("single: got %d", c)
+	}
+	dp := <-dpchan
+	if dp.Tags.String() != "{host=test,k=v}" {
+		t.Errorf("unexpected tags: %v", dp.Tags)
+	}
+	if v := dp.Value.(json.Number); v != "9007199254740993" {
+		t.Errorf("value was rounded: %v", v)
+	}
+	if c := post(`[{"metric":"a.b","timestamp":1,"value":1,"tags":{"host":""}},{"metric":"bad metric","timestamp":1,"value":1}]`, true); c != 400 {
+		t.Fatalf("array: got %d", c)
+	}
+	dp = <-dpchan
+	if len(dp.Tags) != 0 {
+		t.Errorf("expected no tags, got %v", dp.Tags)
+	}
+	if len(dpchan) != 0 {
+		t.Error("bad data point was accepted")
+	}
+	if c := post(`{"metric":`, false); c != 400 {
+		t.Errorf("truncated: got %d", c)
+	}
+}
+
+func TestPutStop(t *testing.T) {
+	util.Hostname = "test"
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &PutListener{Addr: l.Addr().String()}
+	l.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	dpchan := make(chan *opentsdb.DataPoint, 10)
+	done := make(chan struct{})
+	go func() {
+		p.Run(ctx, dpchan)
+		close(done)
+	}()
+	var c net.Conn
+	for i := 0; ; i++ {
+		if c, err = net.Dial("tcp", p.Addr); err == nil {
+			break
+		} else if i == 100 {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	defer c.Close()
+	c.Write([]byte("put a.b 1434055562 1 k=v\n"))
+	if dp := <-dpchan; dp.Metric != "a.b" {
+		t.Fatalf("unexpected data point %v", dp)
+	}
+	cancel()
+	<-done
+	// The connection was closed by the listener.
+	c.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := c.Read(make([]byte, 1)); err == nil {
+		t.Error("connection still open")
+	} else if e, ok := err.(net.Error); ok && e.Timeout() {
+		t.Error("connection still open after stop")
+	}
+}
+
+func TestRelayStop(t *testing.T) {
+	util.Hostname = "test"
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &RelayListener{Addr: l.Addr().String()}
+	l.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	dpchan := make(chan *opentsdb.DataPoint, 10)
+	done := make(chan struct{})
+	go func() {
+		r.Run(ctx, dpchan)
+		close(done)
+	}()
+	var c net.Conn
+	for i := 0; ; i++ {
+		if c, err = net.Dial("tcp", r.Addr); err == nil {
+			break
+		} else if i == 100 {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	defer c.Close()
+	body := `{"metric":"a.b","timestamp":1434055562,"value":1}`
+	fmt.Fprintf(c, "POST /api/put HTTP/1.1\r\nHost: test\r\nContent-Length: %d\r\n\r\n%s", len(body), body)
+	if dp := <-dpchan; dp.Metric != "a.b" {
+		t.Fatalf("unexpected data point %v", dp)
+	}
+	cancel()
+	<-done
+	// The kept alive connection was closed by the server.
+	c.SetReadDeadline(time.Now().Add(time.Second))
+	b := make([]byte, 4096)
+	for {
+		if _, err = c.Read(b); err != nil {
+			break
+		}
+	}
+	if e, ok := err.(net.Error); ok && e.Timeout() {
+		t.Error("connection still open after stop")
+	}
+}
+
+func TestStatsD(t *testing.T) {
+	util.Hostname = "test"
+	s := &StatsDListener{Freq: 10 * time.Second}
+	s.Init()
+	for _, line := range []string{
+		"app.hits:1|c",
+		"app.hits:2|c|@0.5",
+		"app.hits:1|c|#route:home",
+		"app.temp:70|g",
+		"app.temp:-5|g",
+		"app.users:alice|s",
+		"app.users:bob|s",
+		"app.users:alice|s",
+	} {
+		if err := s.handle(line); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 1; i <= 100; i++ {
+		if err := s.handle(fmt.Sprintf("app.latency:%d|ms|#host:", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, bad := range []string{
+		"app.hits",
+		"app.hits:1",
+		"app.hits:x|c",
+		"app.hits:1|q",
+		"app.hits:1|c|@2",
+	} {
+		if err := s.handle(bad); err == nil {
+			t.Errorf("%q: expected error", bad)
+		}
+	}
+	got := make(map[string]interface{})
+	for _, dp := range s.flush() {
+		got[dp.Metric+dp.Tags.String()] = dp.Value
+	}
+	for k, v := range map[string]interface{}{
+		"app.hits.count{host=test}":            5.0,
+		"app.hits.rate{host=test}":             0.5,
+		"app.hits.count{host=test,route=home}": 1.0,
+		"app.temp{host=test}":                  65.0,
+		"app.users.count{host=test}":           2,
+		"app.latency.count{}":                  100.0,
+		"app.latency.min{}":                    1.0,
+		"app.latency.max{}":                    100.0,
+		"app.latency.mean{}":                   50.5,
+		"app.latency.median{}":                 50.0,
+		"app.latency.p95{}":                    95.0,
+		"app.latency.p99{}":                    99.0,
+	} {
+		if got[k] != v {
+			t.Errorf("%s: got %v, expected %v", k, got[k], v)
+		}
+	}
+	got = make(map[string]interface{})
+	for _, dp := range s.flush() {
+		got[dp.Metric+dp.Tags.String()] = dp.Value
+	}
+	if len(got) != 1 || got["app.temp{host=test}"] != 65.0 {
+		t.Errorf("expected only the gauge after reset, got %v", got)
+	}
+
+	// Beyond statsdMaxTimerValues, values are sampled.
+	for i := 1; i <= 3*statsdMaxTimerValues; i++ {
+		if err := s.handle(fmt.Sprintf("app.slow:%d|ms", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got = make(map[string]interface{})
+	for _, dp := range s.flush() {
+		got[dp.Metric] = dp.Value
+	}
+	if got["app.slow.count"] != float64(3*statsdMaxTimerValues) || got["app.slow.min"] != 1.0 ||
+		got["app.slow.max"] != float64(3*statsdMaxTimerValues) || got["app.slow.mean"] != float64(3*statsdMaxTimerValues+1)/2 {
+		t.Errorf("unexpected timer summary: %v", got)
+	}
+	if m := got["app.slow.median"].(float64); math.Abs(m-1.5*statsdMaxTimerValues) > 0.05*statsdMaxTimerValues {
+		t.Errorf("median %v is biased", m)
+	}
+}
+
+func TestStatsDStop(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &StatsDListener{Addr: pc.LocalAddr().String(), Freq: time.Hour}
+	pc.Close()
+	s.Init()
+	ctx, cancel := context.WithCancel(context.Background())
+	dpchan := make(chan *opentsdb.DataPoint, 10)
+	done := make(chan struct{})
+	go func() {
+		s.Run(ctx, dpchan)
+		close(done)
+	}()
+	c, err := net.Dial("udp", s.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	// Writes fail until the listener is up.
+	for i := 0; ; i++ {
+		c.Write([]byte("app.hits:1|c"))
+		time.Sleep(10 * time.Millisecond)
+		s.Lock()
+		n := len(s.counters)
+		s.Unlock()
+		if n > 0 {
+			break
+		}
+		if i == 100 {
+			t.Fatal("listener received nothing")
+		}
+	}
+	cancel()
+	<-done
+	close(dpchan)
+	got := make(map[string]bool)
+	for dp := range dpchan {
+		got[dp.Metric] = true
+	}
+	if !got["app.hits.count"] {
+		t.Errorf("pending counter not sent on stop, got %v", got)
+	}
+}
@@ -14,12 +14,12 @@ func init() {
 }
 
 const (
-	conntrackCount = "/proc/sys/net/netfilter/nf_conntrack_count"
-	conntrackMax   = "/proc/sys/net/netfilter/nf_conntrack_max"
+	conntrackCount = "sys/net/netfilter/nf_conntrack_count"
+	conntrackMax   = "sys/net/netfilter/nf_conntrack_max"
 )
 
 func conntrackEnable() bool {
-	f, err := os.Open(conntrackCount)
+	f, err := os.Open(procPath(conntrackCount))
 	defer f.Close()
 	return err == nil
 }
@@ -27,7 +27,7 @@ func conntrackEnable() bool {
 func c_conntrack_linux() (opentsdb.MultiDataPoint, error) {
 	var md opentsdb.MultiDataPoint
 	var max, count float64
-	if err := readLine(conntrackCount, func(s string) error {
+	if err := readLine(procPath(conntrackCount), func(s string) error {
 		values := strings.Fields(s)
 		if len(values) > 0 {
 			var err error
@@ -41,7 +41,7 @@ func c_conntrack_linux() (opentsdb.MultiDataPoint, error) {
 	}); err != nil {
 		return nil, err
 	}
-	if err := readLine(conntrackMax, func(s string) error {
+	if err := readLine(procPath(conntrackMax), func(s string) error {
 		values := strings.Fields(s)
 		if len(values) > 0 {
 			var err error
@@ -8,7 +8,6 @@ import (
 
 	"github.com/bosun-monitor/scollector/metadata"
 	"github.com/bosun-monitor/scollector/opentsdb"
-	"github.com/bosun-monitor/scollector/util"
 )
 
 func init() {
@@ -258,7 +257,7 @@ func severity(s string) int {
 
 func readOmreport(f func([]string), args ...string) {
 	args = append(args, "-fmt", "ssv")
-	util.ReadCommand(func(line string) error {
+	readCommand(func(line string) error {
 		sp := strings.Split(line, ";")
 		for i, s := range sp {
 			sp[i] = clean(s)
@@ -6,7 +6,6 @@ import (
 
 	"github.com/bosun-monitor/scollector/metadata"
 	"github.com/bosun-monitor/scollector/opentsdb"
-	"github.com/bosun-monitor/scollector/util"
 )
 
 func init() {
@@ -15,7 +14,7 @@ func init() {
 
 func c_dfstat_darwin() (opentsdb.MultiDataPoint, error) {
 	var md opentsdb.MultiDataPoint
-	util.ReadCommand(func(line string) error {
+	readCommand(func(line string) error {
 		fields := strings.Fields(line)
 		if line == "" || len(fields) < 9 || !IsDigit(fields[2]) {
 			return nil
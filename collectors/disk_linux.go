@@ -9,7 +9,6 @@ import (
 
 	"github.com/bosun-monitor/scollector/metadata"
 	"github.com/bosun-monitor/scollector/opentsdb"
-	"github.com/bosun-monitor/scollector/util"
 )
 
 func init() {
@@ -51,7 +50,7 @@ var diskLinuxFieldsPart = []struct {
 func removable(major, minor string) bool {
 	//We don't return an error, because removable may not exist for partitions of a removable device
 	//So this is really "best effort" and we will have to see how it works in practice.
-	b, err := ioutil.ReadFile("/sys/dev/block/" + major + ":" + minor + "/removable")
+	b, err := ioutil.ReadFile(sysPath("dev/block", major+":"+minor, "removable"))
 	if err != nil {
 		return false
 	}
@@ -64,7 +63,7 @@ var sdiskRE = regexp.MustCompile(`/dev/(sd[a-z])[0-9]?`)
 func removable_fs(name string) bool {
 	s := sdiskRE.FindStringSubmatch(name)
 	if len(s) > 1 {
-		b, err := ioutil.ReadFile("/sys/block/" + s[1] + "/removable")
+		b, err := ioutil.ReadFile(sysPath("block", s[1], "removable"))
 		if err != nil {
 			return false
 		}
@@ -76,7 +75,7 @@ func removable_fs(name string) bool {
 func c_iostat_linux() (opentsdb.MultiDataPoint, error) {
 	var md opentsdb.MultiDataPoint
 	var removables []string
-	err := readLine("/proc/diskstats", func(s string) error {
+	err := readLine(procPath("diskstats"), func(s string) error {
 		values := strings.Fields(s)
 		if len(values) < 4 {
 			return nil
@@ -135,7 +134,7 @@ func c_iostat_linux() (opentsdb.MultiDataPoint, error) {
 
 func c_dfstat_blocks_linux() (opentsdb.MultiDataPoint, error) {
 	var md opentsdb.MultiDataPoint
-	err := util.ReadCommand(func(line string) error {
+	err := readCommand(func(line string) error {
 		fields := strings.Fields(line)
 		// TODO: support mount points with spaces in them. They mess up the field order
 		// currently due to df's columnar output.
@@ -170,7 +169,7 @@ func c_dfstat_blocks_linux() (opentsdb.MultiDataPoint, error) {
 
 func c_dfstat_inodes_linux() (opentsdb.MultiDataPoint, error) {
 	var md opentsdb.MultiDataPoint
-	err := util.ReadCommand(func(line string) error {
+	err := readCommand(func(line string) error {
 		fields := strings.Fields(line)
 		if len(fields) != 6 || !IsDigit(fields[2]) {
 			return nil
@@ -0,0 +1,254 @@
+package collectors
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bosun-monitor/scollector/metadata"
+	"github.com/bosun-monitor/scollector/opentsdb"
+)
+
+func init() {
+	collectors = append(collectors, &IntervalCollector{F: c_docker, Enable: dockerEnable})
+}
+
+var dockerClient = &http.Client{
+	Transport: &http.Transport{
+		Dial: func(network, addr string) (net.Conn, error) {
+			return net.DialTimeout("unix", DockerSocket, time.Second*5)
+		},
+	},
+	Timeout: time.Second * 30,
+}
+
+func dockerEnable() bool {
+	_, err := os.Stat(DockerSocket)
+	return err == nil
+}
+
+func dockerReq(path string, v interface{}) error {
+	resp, err := dockerClient.Get("http://docker" + path)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("docker: %s: %s", path, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+type dockerContainer struct {
+	ID     string `json:"Id"`
+	Names  []string
+	Image  string
+	Labels map[string]string
+}
+
+// tags returns the tags of c's data points: its short ID, name and image, and
+// the labels in DockerLabels it has.
+func (c *dockerContainer) tags() opentsdb.TagSet {
+	ts := opentsdb.TagSet{"container": c.ID}
+	if len(c.ID) > 12 {
+		ts["container"] = c.ID[:12]
+	}
+	if len(c.Names) > 0 {
+		ts["name"] = strings.TrimPrefix(c.Names[0], "/")
+	}
+	ts["image"] = c.Image
+	for _, l := range DockerLabels {
+		k := opentsdb.MustReplace(l, "_")
+		if _, ok := ts[k]; !ok && k != "host" {
+			ts[k] = c.Labels[l]
+		}
+	}
+	for k, v := range ts {
+		if v = opentsdb.MustReplace(v, "_"); v == "" {
+			delete(ts, k)
+		} else {
+			ts[k] = v
+		}
+	}
+	return ts
+}
+
+type dockerStats struct {
+	CPUStats struct {
+		CPUUsage struct {
+			TotalUsage        uint64 `json:"total_usage"`
+			UsageInUsermode   uint64 `json:"usage_in_usermode"`
+			UsageInKernelmode uint64 `json:"usage_in_kernelmode"`
+		} `json:"cpu_usage"`
+		ThrottlingData struct {
+			Periods          uint64 `json:"periods"`
+			ThrottledPeriods uint64 `json:"throttled_periods"`
+			ThrottledTime    uint64 `json:"throttled_time"`
+		} `json:"throttling_data"`
+	} `json:"cpu_stats"`
+	MemoryStats struct {
+		Usage uint64            `json:"usage"`
+		Limit uint64            `json:"limit"`
+		Stats map[string]uint64 `json:"stats"`
+	} `json:"memory_stats"`
+	Networks   map[string]map[string]uint64 `json:"networks"`
+	BlkioStats struct {
+		IOServiceBytesRecursive []dockerBlkioEntry `json:"io_service_bytes_recursive"`
+		IOServicedRecursive     []dockerBlkioEntry `json:"io_serviced_recursive"`
+	} `json:"blkio_stats"`
+	PidsStats struct {
+		Current uint64 `json:"current"`
+	} `json:"pids_stats"`
+}
+
+type dockerBlkioEntry struct {
+	Major uint64 `json:"major"`
+	Minor uint64 `json:"minor"`
+	Op    string `json:"op"`
+	Value uint64 `json:"value"`
+}
+
+// dockerConcurrency is how many container stats requests c_docker makes at
+// once.
+const dockerConcurrency = 4
+
+// c_docker reports the resource usage of the running Docker containers. The
+// stats of up to dockerConcurrency containers are requested concurrently since
+// the daemon may take a second to produce them.
+func c_docker() (opentsdb.MultiDataPoint, error) {
+	var containers []dockerContainer
+	if err := dockerReq("/containers/json", &containers); err != nil {
+		return nil, err
+	}
+	var md opentsdb.MultiDataPoint
+	Add(&md, "docker.containers", len(containers), nil, metadata.Gauge, metadata.Count, descDockerContainers)
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs []error
+		sem  = make(chan struct{}, dockerConcurrency)
+	)
+	for i := range containers {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(c *dockerContainer) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			var s dockerStats
+			err := dockerReq("/containers/"+c.ID+"/stats?stream=false&one-shot=true", &s)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs = append(errs, err)
+				return
+			}
+			dockerAddStats(&md, &s, c.tags())
+		}(&containers[i])
+	}
+	wg.Wait()
+	// A container may stop between the list and the stats requests, so only
+	// fail if none could be read.
+	if len(errs) > 0 && len(errs) == len(containers) {
+		return nil, errs[0]
+	}
+	return md, nil
+}
+
+const (
+	descDockerContainers = "Number of running containers."
+	descDockerCPUUsage   = "Total CPU time consumed by the container."
+	descDockerPeriods    = "Number of enforcement periods elapsed while the container had a CPU quota."
+	descDockerThrottled  = "Number of enforcement periods in which the container was throttled."
+	descDockerThrTime    = "Total time the container was throttled."
+	descDockerMemUsage   = "Memory used by the container, including page cache."
+	descDockerMemLimit   = "Memory limit of the container, or of the host if it has none."
+	descDockerMemRSS     = "Anonymous memory used by the container."
+	descDockerMemCache   = "Page cache memory used by the container."
+	descDockerNetBytes   = "Bytes received or sent on the container's interface."
+	descDockerNetPackets = "Packets received or sent on the container's interface."
+	descDockerNetErrs    = "Receive or transmit errors on the container's interface."
+	descDockerNetDropped = "Packets dropped on the container's interface."
+	descDockerIOBytes    = "Bytes read from or written to block devices by the container."
+	descDockerIOOps      = "Read or write operations on block devices by the container."
+	descDockerPids       = "Number of tasks in the container."
+)
+
+var dockerNetFields = []struct {
+	key, metric, direction string
+	unit                   metadata.Unit
+	desc                   string
+}{
+	{"rx_bytes", "bytes", "in", metadata.Bytes, descDockerNetBytes},
+	{"tx_bytes", "bytes", "out", metadata.Bytes, descDockerNetBytes},
+	{"rx_packets", "packets", "in", metadata.Count, descDockerNetPackets},
+	{"tx_packets", "packets", "out", metadata.Count, descDockerNetPackets},
+	{"rx_errors", "errs", "in", metadata.Count, descDockerNetErrs},
+	{"tx_errors", "errs", "out", metadata.Count, descDockerNetErrs},
+	{"rx_dropped", "dropped", "in", metadata.Count, descDockerNetDropped},
+	{"tx_dropped", "dropped", "out", metadata.Count, descDockerNetDropped},
+}
+
+func dockerAddStats(md *opentsdb.MultiDataPoint, s *dockerStats, ts opentsdb.TagSet) {
+	cpu := s.CPUStats
+	Add(md, "docker.cpu.usage", float64(cpu.CPUUsage.TotalUsage)/1e9, ts, metadata.Counter, metadata.Second, descDockerCPUUsage)
+	Add(md, "docker.cpu.user", float64(cpu.CPUUsage.UsageInUsermode)/1e9, ts, metadata.Counter, metadata.Second, "")
+	Add(md, "docker.cpu.system", float64(cpu.CPUUsage.UsageInKernelmode)/1e9, ts, metadata.Counter, metadata.Second, "")
+	Add(md, "docker.cpu.periods", cpu.ThrottlingData.Periods, ts, metadata.Counter, metadata.Count, descDockerPeriods)
+	Add(md, "docker.cpu.throttled", cpu.ThrottlingData.ThrottledPeriods, ts, metadata.Counter, metadata.Count, descDockerThrottled)
+	Add(md, "docker.cpu.throttled_time", float64(cpu.ThrottlingData.ThrottledTime)/1e9, ts, metadata.Counter, metadata.Second, descDockerThrTime)
+
+	mem := s.MemoryStats
+	Add(md, "docker.mem.usage", mem.Usage, ts, metadata.Gauge, metadata.Bytes, descDockerMemUsage)
+	Add(md, "docker.mem.limit", mem.Limit, ts, metadata.Gauge, metadata.Bytes, descDockerMemLimit)
+	// The keys of memory.stat differ between cgroup v1 and v2.
+	if v, ok := mem.Stats["rss"]; ok {
+		Add(md, "docker.mem.rss", v, ts, metadata.Gauge, metadata.Bytes, descDockerMemRSS)
+	} else if v, ok := mem.Stats["anon"]; ok {
+		Add(md, "docker.mem.rss", v, ts, metadata.Gauge, metadata.Bytes, descDockerMemRSS)
+	}
+	if v, ok := mem.Stats["cache"]; ok {
+		Add(md, "docker.mem.cache", v, ts, metadata.Gauge, metadata.Bytes, descDockerMemCache)
+	} else if v, ok := mem.Stats["file"]; ok {
+		Add(md, "docker.mem.cache", v, ts, metadata.Gauge, metadata.Bytes, descDockerMemCache)
+	}
+
+	for iface, stats := range s.Networks {
+		for _, f := range dockerNetFields {
+			v, ok := stats[f.key]
+			if !ok {
+				continue
+			}
+			tags := ts.Copy().Merge(opentsdb.TagSet{"iface": iface, "direction": f.direction})
+			Add(md, "docker.net."+f.metric, v, tags, metadata.Counter, f.unit, f.desc)
+		}
+	}
+
+	for _, b := range []struct {
+		entries []dockerBlkioEntry
+		metric  string
+		unit    metadata.Unit
+		desc    string
+	}{
+		{s.BlkioStats.IOServiceBytesRecursive, "bytes", metadata.Bytes, descDockerIOBytes},
+		{s.BlkioStats.IOServicedRecursive, "ops", metadata.Operation, descDockerIOOps},
+	} {
+		for _, e := range b.entries {
+			direction := strings.ToLower(e.Op)
+			if direction != "read" && direction != "write" {
+				continue
+			}
+			tags := ts.Copy().Merge(opentsdb.TagSet{
+				"dev":       blockDevName(fmt.Sprintf("%d:%d", e.Major, e.Minor)),
+				"direction": direction,
+			})
+			Add(md, "docker.io."+b.metric, e.Value, tags, metadata.Counter, b.unit, b.desc)
+		}
+	}
+
+	Add(md, "docker.pids", s.PidsStats.Current, ts, metadata.Gauge, metadata.Process, descDockerPids)
+}
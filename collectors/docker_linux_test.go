@@ -0,0 +1,42 @@
+package collectors
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestFixtureDocker serves the Engine API from testdata/docker_linux/api on a
+// unix socket, with files named like those of fixtureTransport.
+func TestFixtureDocker(t *testing.T) {
+	dir, err := ioutil.TempDir("", "scollector")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	l, err := net.Listen("unix", filepath.Join(dir, "docker.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := fixtureNameRE.ReplaceAllString(r.URL.RequestURI(), "_")
+		http.ServeFile(w, r, filepath.Join("testdata", "docker_linux", "api", name))
+	}))
+	s.Listener = l
+	s.Start()
+	defer s.Close()
+
+	defer func(sock string, labels []string) {
+		DockerSocket, DockerLabels = sock, labels
+	}(DockerSocket, DockerLabels)
+	DockerSocket = l.Addr().String()
+	DockerLabels = []string{"com.example.team", "host"}
+	if !dockerEnable() {
+		t.Fatal("not enabled with a socket")
+	}
+	testFixture(t, "docker_linux", c_docker)
+}
@@ -0,0 +1,57 @@
+package collectors
+
+import "testing"
+
+func TestFixtureProcstats(t *testing.T) {
+	testFixture(t, "procstats_linux", c_procstats_linux)
+}
+
+func TestFixtureIfstat(t *testing.T) {
+	testFixture(t, "ifstat_linux", c_ifstat_linux)
+}
+
+func TestFixtureIPCount(t *testing.T) {
+	testFixture(t, "ipcount_linux", c_ipcount_linux)
+}
+
+func TestFixtureIostat(t *testing.T) {
+	testFixture(t, "iostat_linux", c_iostat_linux)
+}
+
+func TestFixtureDfstat(t *testing.T) {
+	testFixture(t, "dfstat_linux", collectAll(c_dfstat_blocks_linux, c_dfstat_inodes_linux))
+}
+
+func TestFixtureConntrack(t *testing.T) {
+	testFixture(t, "conntrack_linux", c_conntrack_linux)
+}
+
+func TestFixtureYum(t *testing.T) {
+	testFixture(t, "yum_update_linux", yum_update_stats_linux)
+}
+
+func TestFixtureRailgun(t *testing.T) {
+	defer func(u string) { rgURL = u }(rgURL)
+	rgURL = "http://127.0.0.1:24088"
+	testFixture(t, "railgun", c_railgun)
+}
+
+func TestFixtureCgroupV1(t *testing.T) {
+	testFixture(t, "cgroup_v1_linux", c_cgroup_linux)
+}
+
+func TestFixtureCgroupV2(t *testing.T) {
+	testFixture(t, "cgroup_v2_linux", c_cgroup_linux)
+}
+
+func TestFixturePressure(t *testing.T) {
+	testFixture(t, "pressure_linux", c_pressure_linux)
+}
+
+func TestFixtureMdstat(t *testing.T) {
+	testFixture(t, "mdstat_linux", c_mdstat_linux)
+}
+
+func TestFixtureSmart(t *testing.T) {
+	testFixture(t, "smart_linux", c_smart_linux)
+}
@@ -0,0 +1,169 @@
+package collectors
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bosun-monitor/scollector/opentsdb"
+	"github.com/bosun-monitor/scollector/util"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files of fixture tests")
+
+// testFixture runs f against the recorded inputs in testdata/name and
+// compares the data points it returns to testdata/name/golden.txt, one per
+// line as "metric value tags", sorted. Timestamps are ignored and the host tag
+// is "test". The directory may contain:
+//
+//	proc, sys  used as ProcRoot and SysRoot
+//	commands   the output of programs run with runCommand, in files named
+//	           after the command line with / replaced by _, such as
+//	           "df -liP"; other programs are reported as not installed
+//	http       bodies of the responses to HTTP GET requests, in files named
+//	           after the host and request URI with characters other than
+//	           letters, digits, - and . replaced by _, such as
+//	           "localhost_9200__nodes__local_stats"; other requests get a 404
+//
+// Run go test -update to write the golden files from the current output.
+func testFixture(t *testing.T, name string, f func() (opentsdb.MultiDataPoint, error)) {
+	dir := filepath.Join("testdata", name)
+	if _, err := os.Stat(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer func(proc, sys, host string, run func(time.Duration, string, ...string) ([]byte, error), transport http.RoundTripper) {
+		ProcRoot, SysRoot, util.Hostname = proc, sys, host
+		runCommand = run
+		http.DefaultTransport = transport
+	}(ProcRoot, SysRoot, util.Hostname, runCommand, http.DefaultTransport)
+	ProcRoot = filepath.Join(dir, "proc")
+	SysRoot = filepath.Join(dir, "sys")
+	util.Hostname = "test"
+	runCommand = func(timeout time.Duration, name string, arg ...string) ([]byte, error) {
+		cmdline := strings.Join(append([]string{name}, arg...), " ")
+		b, err := ioutil.ReadFile(filepath.Join(dir, "commands", strings.Replace(cmdline, "/", "_", -1)))
+		if os.IsNotExist(err) {
+			return nil, util.ErrPath
+		}
+		return b, err
+	}
+	http.DefaultTransport = fixtureTransport(filepath.Join(dir, "http"))
+
+	md, err := f()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := formatFixture(md)
+	golden := filepath.Join(dir, "golden.txt")
+	if *update {
+		if err := ioutil.WriteFile(golden, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := ioutil.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("data points differ from %s (run go test -update to accept):\n%s", golden, diffLines(want, got))
+	}
+}
+
+func formatFixture(md opentsdb.MultiDataPoint) []byte {
+	lines := make([]string, len(md))
+	for i, dp := range md {
+		lines[i] = fmt.Sprintf("%s %v %s\n", dp.Metric, dp.Value, dp.Tags.Tags())
+	}
+	sort.Strings(lines)
+	return []byte(strings.Join(lines, ""))
+}
+
+// diffLines returns the lines only in want, prefixed with -, and those only in
+// got, prefixed with +.
+func diffLines(want, got []byte) string {
+	count := make(map[string]int)
+	for _, l := range strings.SplitAfter(string(want), "\n") {
+		count[l]++
+	}
+	for _, l := range strings.SplitAfter(string(got), "\n") {
+		count[l]--
+	}
+	var b bytes.Buffer
+	for _, l := range strings.SplitAfter(string(want), "\n") {
+		if count[l] > 0 {
+			fmt.Fprintf(&b, "-%s", l)
+			count[l]--
+		}
+	}
+	for _, l := range strings.SplitAfter(string(got), "\n") {
+		if count[l] < 0 {
+			fmt.Fprintf(&b, "+%s", l)
+			count[l]++
+		}
+	}
+	return b.String()
+}
+
+var fixtureNameRE = regexp.MustCompile(`[^A-Za-z0-9.-]`)
+
+// fixtureTransport serves HTTP requests from the files in dir.
+type fixtureTransport string
+
+func (dir fixtureTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	name := fixtureNameRE.ReplaceAllString(r.URL.Host+r.URL.RequestURI(), "_")
+	resp := &http.Response{
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     make(http.Header),
+		Request:    r,
+	}
+	b, err := ioutil.ReadFile(filepath.Join(string(dir), name))
+	if os.IsNotExist(err) {
+		resp.StatusCode = http.StatusNotFound
+		resp.Status = "404 Not Found"
+		resp.Body = ioutil.NopCloser(strings.NewReader(name + " not recorded\n"))
+		return resp, nil
+	} else if err != nil {
+		return nil, err
+	}
+	resp.StatusCode = http.StatusOK
+	resp.Status = "200 OK"
+	resp.Header.Set("Content-Type", "application/json")
+	resp.Body = ioutil.NopCloser(bytes.NewReader(b))
+	return resp, nil
+}
+
+// collectAll returns a collector function that returns the data points of all
+// of fs, for fixtures shared by several collectors.
+func collectAll(fs ...func() (opentsdb.MultiDataPoint, error)) func() (opentsdb.MultiDataPoint, error) {
+	return func() (opentsdb.MultiDataPoint, error) {
+		var md opentsdb.MultiDataPoint
+		for _, f := range fs {
+			m, err := f()
+			if err != nil {
+				return nil, err
+			}
+			md = append(md, m...)
+		}
+		return md, nil
+	}
+}
+
+func TestFixtureElasticsearch(t *testing.T) {
+	testFixture(t, "elasticsearch", c_elasticsearch)
+}
+
+func TestFixtureOmreport(t *testing.T) {
+	testFixture(t, "omreport", collectAll(c_omreport_chassis, c_omreport_ps))
+}
@@ -0,0 +1,17 @@
+// +build darwin linux
+
+package collectors
+
+import "testing"
+
+func TestFixtureNTP(t *testing.T) {
+	testFixture(t, "ntp_unix", c_ntp_peers_unix)
+}
+
+func TestFixtureCassandra(t *testing.T) {
+	testFixture(t, "cassandra", c_nodestats_cfstats_linux)
+}
+
+func TestFixtureHBase(t *testing.T) {
+	testFixture(t, "hbase", collectAll(c_hbase_region, c_hbase_replication, c_hbase_gc))
+}
@@ -15,13 +15,15 @@ type response struct {
 	rtt  time.Duration
 }
 
-// ICMP registers an ICMP collector a given host.
-func ICMP(host string) {
+// ICMP registers an ICMP collector a given host. Interval defaults to
+// DefaultFreq if 0.
+func ICMP(host string, interval time.Duration) {
 	collectors = append(collectors, &IntervalCollector{
 		F: func() (opentsdb.MultiDataPoint, error) {
 			return c_icmp(host)
 		},
-		name: fmt.Sprintf("icmp-%s", host),
+		Interval: interval,
+		name:     fmt.Sprintf("icmp-%s", host),
 	})
 }
 
@@ -6,7 +6,6 @@ import (
 
 	"github.com/bosun-monitor/scollector/metadata"
 	"github.com/bosun-monitor/scollector/opentsdb"
-	"github.com/bosun-monitor/scollector/util"
 )
 
 func init() {
@@ -44,7 +43,7 @@ func c_ipcount_linux() (opentsdb.MultiDataPoint, error) {
 	var md opentsdb.MultiDataPoint
 	v4c := 0
 	v6c := 0
-	err := util.ReadCommand(func(line string) error {
+	err := readCommand(func(line string) error {
 		tl := strings.TrimSpace(line)
 		if strings.HasPrefix(tl, "inet ") {
 			v4c++
@@ -71,7 +70,7 @@ func c_ifstat_linux() (opentsdb.MultiDataPoint, error) {
 			return "in"
 		}
 	}
-	err := readLine("/proc/net/dev", func(s string) error {
+	err := readLine(procPath("net/dev"), func(s string) error {
 		m := ifstatRE.FindStringSubmatch(s)
 		if m == nil {
 			return nil
@@ -84,7 +83,7 @@ func c_ifstat_linux() (opentsdb.MultiDataPoint, error) {
 			bond_string = "bond."
 		}
 		// Detect speed of the interface in question
-		readLine("/sys/class/net/"+intf+"/speed", func(speed string) error {
+		readLine(sysPath("class/net", intf, "speed"), func(speed string) error {
 			Add(&md, "linux.net."+bond_string+"ifspeed", speed, tags, metadata.Gauge, metadata.Megabit, "")
 			Add(&md, "os.net."+bond_string+"ifspeed", speed, tags, metadata.Gauge, metadata.Megabit, "")
 			return nil
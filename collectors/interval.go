@@ -1,26 +1,37 @@
 package collectors
 
 import (
+	"context"
+	"fmt"
 	"net/http"
 	"reflect"
 	"runtime"
+	"runtime/debug"
 	"sync"
 	"time"
 
-	"github.com/bosun-monitor/scollector/opentsdb"
 	"github.com/StackExchange/slog"
+	"github.com/bosun-monitor/scollector/opentsdb"
 )
 
 type IntervalCollector struct {
 	F        func() (opentsdb.MultiDataPoint, error)
 	Interval time.Duration // defaults to DefaultFreq if unspecified
+	Timeout  time.Duration // defaults to DefaultTimeout if unspecified
 	Enable   func() bool
 	name     string
 	init     func()
 
+	// settings describes what the collector was registered with, if not
+	// implied by its name, so a reload can tell whether it changed.
+	settings string
+
 	// internal use
 	sync.Mutex
 	enabled bool
+	busy    bool // F is running, possibly past its timeout
+	stats   runStats
+	now     trigger
 }
 
 func (c *IntervalCollector) Init() {
@@ -29,7 +40,8 @@ func (c *IntervalCollector) Init() {
 	}
 }
 
-func (c *IntervalCollector) Run(dpchan chan<- *opentsdb.DataPoint) {
+func (c *IntervalCollector) Run(ctx context.Context, dpchan chan<- *opentsdb.DataPoint) {
+	defer c.stats.stop(c.Name())
 	if c.Enable != nil {
 		go func() {
 			for {
@@ -37,10 +49,15 @@ func (c *IntervalCollector) Run(dpchan chan<- *opentsdb.DataPoint) {
 				c.Lock()
 				c.enabled = c.Enable()
 				c.Unlock()
-				<-next
+				select {
+				case <-next:
+				case <-ctx.Done():
+					return
+				}
 			}
 		}()
 	}
+	now := c.now.channel()
 	for {
 		interval := c.Interval
 		if interval == 0 {
@@ -48,7 +65,9 @@ func (c *IntervalCollector) Run(dpchan chan<- *opentsdb.DataPoint) {
 		}
 		next := time.After(interval)
 		if c.Enabled() {
-			md, err := c.F()
+			start := time.Now()
+			md, err := c.call()
+			c.stats.record(c.Name(), start, len(md), err)
 			if err != nil {
 				slog.Errorf("%v: %v", c.Name(), err)
 			}
@@ -56,7 +75,59 @@ func (c *IntervalCollector) Run(dpchan chan<- *opentsdb.DataPoint) {
 				dpchan <- dp
 			}
 		}
-		<-next
+		select {
+		case <-next:
+		case <-now:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (c *IntervalCollector) runNow() bool {
+	c.now.fire()
+	return true
+}
+
+// call runs F, returning an error if it panics or takes longer than the
+// timeout. A late result is discarded. F is not called again until it has
+// returned.
+func (c *IntervalCollector) call() (opentsdb.MultiDataPoint, error) {
+	c.Lock()
+	if c.busy {
+		c.Unlock()
+		return nil, fmt.Errorf("previous run still in progress")
+	}
+	c.busy = true
+	c.Unlock()
+	type result struct {
+		md  opentsdb.MultiDataPoint
+		err error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				countEvent("collector.panics", c.Name())
+				ch <- result{nil, fmt.Errorf("panic: %v\n%s", r, debug.Stack())}
+			}
+			c.Lock()
+			c.busy = false
+			c.Unlock()
+		}()
+		md, err := c.F()
+		ch <- result{md, err}
+	}()
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+	select {
+	case r := <-ch:
+		return r.md, r.err
+	case <-time.After(timeout):
+		countEvent("collector.timeouts", c.Name())
+		return nil, fmt.Errorf("timed out after %v", timeout)
 	}
 }
 
@@ -0,0 +1,57 @@
+package collectors
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bosun-monitor/scollector/opentsdb"
+)
+
+func TestIntervalCollectorCall(t *testing.T) {
+	c := &IntervalCollector{
+		F: func() (opentsdb.MultiDataPoint, error) {
+			var m map[string]int
+			m["x"]++
+			return nil, nil
+		},
+		name: "panics",
+	}
+	if _, err := c.call(); err == nil || !strings.HasPrefix(err.Error(), "panic: ") {
+		t.Errorf("expected panic error, got %v", err)
+	}
+
+	release := make(chan struct{})
+	c = &IntervalCollector{
+		F: func() (opentsdb.MultiDataPoint, error) {
+			<-release
+			return opentsdb.MultiDataPoint{{}}, nil
+		},
+		Timeout: time.Millisecond * 10,
+		name:    "hangs",
+	}
+	if md, err := c.call(); err == nil || md != nil {
+		t.Errorf("expected timeout, got %v, %v", md, err)
+	}
+	if _, err := c.call(); err == nil || !strings.Contains(err.Error(), "in progress") {
+		t.Errorf("expected previous run in progress, got %v", err)
+	}
+	close(release)
+	time.Sleep(time.Millisecond * 10)
+	if md, err := c.call(); err != nil || len(md) != 1 {
+		t.Errorf("expected a data point once released, got %v, %v", md, err)
+	}
+}
+
+func TestStatName(t *testing.T) {
+	for name, want := range map[string]string{
+		"github.com/bosun-monitor/scollector/collectors.c_cpu_linux": "c_cpu_linux",
+		"snmp-ifaces-10.0.0.1":      "snmp-ifaces-10.0.0.1",
+		"/opt/scollector/15/app.sh": "app.sh",
+		"put-:4243":                 "put-_4243",
+	} {
+		if got := statName(name); got != want {
+			t.Errorf("%s: got %s, expected %s", name, got, want)
+		}
+	}
+}
@@ -6,7 +6,6 @@ import (
 
 	"github.com/bosun-monitor/scollector/metadata"
 	"github.com/bosun-monitor/scollector/opentsdb"
-	"github.com/bosun-monitor/scollector/util"
 )
 
 func init() {
@@ -18,7 +17,7 @@ func c_iostat_darwin() (opentsdb.MultiDataPoint, error) {
 	var md opentsdb.MultiDataPoint
 	ln := 0
 	i := 0
-	util.ReadCommand(func(line string) error {
+	readCommand(func(line string) error {
 		ln++
 		if ln == 1 {
 			categories = strings.Fields(line)
@@ -0,0 +1,194 @@
+package collectors
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/bosun-monitor/scollector/metadata"
+	"github.com/bosun-monitor/scollector/opentsdb"
+)
+
+func init() {
+	collectors = append(collectors, &IntervalCollector{F: c_mdstat_linux, Enable: mdstatEnable})
+}
+
+func mdstatEnable() bool {
+	_, err := os.Stat(procPath("mdstat"))
+	return err == nil
+}
+
+// mdArray is a software RAID array as described by /proc/mdstat.
+type mdArray struct {
+	name     string
+	active   bool
+	level    string
+	members  int
+	disks    int // number of member disks of a complete array
+	inSync   int
+	failed   int
+	spare    int
+	progress float64 // percent of the current resync, recovery or reshape
+	speed    float64 // in KB per second
+	syncing  bool
+}
+
+var (
+	mdstatArrayRE  = regexp.MustCompile(`^(md\S+) : (\S+)(.*)$`)
+	mdstatMemberRE = regexp.MustCompile(`^\S+\[\d+\]((?:\([A-Z]\))*)$`)
+	mdstatStatusRE = regexp.MustCompile(`\[(\d+)/(\d+)\] \[[U_]+\]`)
+	mdstatSyncRE   = regexp.MustCompile(`(resync|recovery|reshape|check|repair) *= *([\d.]+)%`)
+	mdstatSpeedRE  = regexp.MustCompile(`speed=([\d.]+)K/sec`)
+)
+
+// parseMdstat parses the content of /proc/mdstat, such as:
+//
+//	md1 : active raid5 sdc1[3] sdb2[1] sda2[0] sdd1[4](S) sde1[5](F)
+//	      2095104 blocks super 1.2 level 5, 512k chunk, algorithm 2 [3/2] [UU_]
+//	      [==>..................]  recovery = 12.6% (132096/1047552) finish=0.6min speed=22016K/sec
+func parseMdstat(lines []string) ([]*mdArray, error) {
+	var arrays []*mdArray
+	var a *mdArray
+	for _, l := range lines {
+		if m := mdstatArrayRE.FindStringSubmatch(l); m != nil {
+			a = &mdArray{name: m[1], active: m[2] == "active"}
+			arrays = append(arrays, a)
+			for _, f := range strings.Fields(m[3]) {
+				mm := mdstatMemberRE.FindStringSubmatch(f)
+				if mm == nil {
+					if a.level == "" && !strings.HasPrefix(f, "(") {
+						a.level = f
+					}
+					continue
+				}
+				a.members++
+				switch {
+				case strings.Contains(mm[1], "(F)"):
+					a.failed++
+				case strings.Contains(mm[1], "(S)"):
+					a.spare++
+				}
+			}
+			continue
+		}
+		if a == nil || strings.TrimSpace(l) == "" {
+			a = nil
+			continue
+		}
+		if m := mdstatStatusRE.FindStringSubmatch(l); m != nil {
+			var err error
+			if a.disks, err = strconv.Atoi(m[1]); err != nil {
+				return nil, err
+			}
+			if a.inSync, err = strconv.Atoi(m[2]); err != nil {
+				return nil, err
+			}
+		}
+		if m := mdstatSyncRE.FindStringSubmatch(l); m != nil {
+			p, err := strconv.ParseFloat(m[2], 64)
+			if err != nil {
+				return nil, err
+			}
+			a.progress, a.syncing = p, true
+		}
+		if m := mdstatSpeedRE.FindStringSubmatch(l); m != nil {
+			s, err := strconv.ParseFloat(m[1], 64)
+			if err != nil {
+				return nil, err
+			}
+			a.speed = s
+		}
+	}
+	// Arrays without redundancy, such as raid0, have no [n/m] status, and
+	// all their members are needed.
+	for _, a := range arrays {
+		if a.active && a.disks == 0 {
+			a.disks = a.members - a.spare
+			a.inSync = a.disks - a.failed
+		}
+	}
+	return arrays, nil
+}
+
+// mdStateOk are the array_state values of arrays that work normally.
+var mdStateOk = map[string]bool{
+	"clean":         true,
+	"active":        true,
+	"active-idle":   true,
+	"write-pending": true,
+	"read-auto":     true,
+	"readonly":      true,
+}
+
+const (
+	descMdState      = "0 if the array works normally, 1 if it is inactive, stopped or failed."
+	descMdDegraded   = "1 if the array is missing member disks, else 0."
+	descMdDisks      = "Number of member disks of the complete array."
+	descMdActive     = "Number of member disks in sync."
+	descMdFailed     = "Number of member disks marked faulty."
+	descMdSpare      = "Number of spare disks."
+	descMdSyncing    = "1 if a resync, recovery, reshape or check of the array is in progress, else 0."
+	descMdProgress   = "Progress of the current resync, recovery, reshape or check of the array, or 100 if there is none."
+	descMdSpeed      = "Speed of the current resync, recovery, reshape or check of the array."
+	descMdMismatches = "Number of sectors found inconsistent by the last check or repair."
+)
+
+// c_mdstat_linux reports the health of the Linux software RAID arrays, from
+// /proc/mdstat and, when available, /sys/block/md*/md.
+func c_mdstat_linux() (opentsdb.MultiDataPoint, error) {
+	var lines []string
+	if err := readLine(procPath("mdstat"), func(s string) error {
+		lines = append(lines, s)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	arrays, err := parseMdstat(lines)
+	if err != nil {
+		return nil, fmt.Errorf("mdstat: %v", err)
+	}
+	var md opentsdb.MultiDataPoint
+	for _, a := range arrays {
+		ts := opentsdb.TagSet{"dev": a.name}
+		if a.level != "" {
+			ts["level"] = a.level
+		}
+		state := 0
+		if !a.active {
+			state = 1
+		}
+		readLine(sysPath("block", a.name, "md", "array_state"), func(s string) error {
+			state = 0
+			if !mdStateOk[strings.TrimSpace(s)] {
+				state = 1
+			}
+			return nil
+		})
+		degraded := 0
+		if a.inSync < a.disks {
+			degraded = 1
+		}
+		progress, syncing := 100.0, 0
+		if a.syncing {
+			progress, syncing = a.progress, 1
+		}
+		Add(&md, "linux.md.state", state, ts, metadata.Gauge, metadata.Ok, descMdState)
+		Add(&md, "linux.md.degraded", degraded, ts, metadata.Gauge, metadata.Bool, descMdDegraded)
+		Add(&md, "linux.md.disks", a.disks, ts, metadata.Gauge, metadata.Count, descMdDisks)
+		Add(&md, "linux.md.disks_active", a.inSync, ts, metadata.Gauge, metadata.Count, descMdActive)
+		Add(&md, "linux.md.disks_failed", a.failed, ts, metadata.Gauge, metadata.Count, descMdFailed)
+		Add(&md, "linux.md.disks_spare", a.spare, ts, metadata.Gauge, metadata.Count, descMdSpare)
+		Add(&md, "linux.md.syncing", syncing, ts, metadata.Gauge, metadata.Bool, descMdSyncing)
+		Add(&md, "linux.md.sync_progress", progress, ts, metadata.Gauge, metadata.Pct, descMdProgress)
+		Add(&md, "linux.md.sync_speed", a.speed*1024, ts, metadata.Gauge, metadata.BytesPerSecond, descMdSpeed)
+		readLine(sysPath("block", a.name, "md", "mismatch_cnt"), func(s string) error {
+			if v, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64); err == nil {
+				Add(&md, "linux.md.mismatches", v, ts, metadata.Gauge, metadata.Count, descMdMismatches)
+			}
+			return nil
+		})
+	}
+	return md, nil
+}
@@ -118,7 +118,7 @@ func nbUnmarhsall(reader *csv.Reader, v interface{}) error {
 func c_netbackup_jobs() (opentsdb.MultiDataPoint, error) {
 	var md opentsdb.MultiDataPoint
 	latest := make(map[string]nbJob)
-	if err := util.ReadCommand(func(line string) error {
+	if err := readCommand(func(line string) error {
 		if len(line) < 32 {
 			return nil
 		}
@@ -162,7 +162,7 @@ func c_netbackup_frequency() (opentsdb.MultiDataPoint, error) {
 	var md opentsdb.MultiDataPoint
 	var class, schedule string
 	var clients []string
-	if err := util.ReadCommand(func(line string) error {
+	if err := readCommand(func(line string) error {
 		if strings.HasPrefix(line, "Policy Name:") {
 			clients = nil
 			f := strings.Fields(line)
@@ -9,7 +9,6 @@ import (
 
 	"github.com/bosun-monitor/scollector/metadata"
 	"github.com/bosun-monitor/scollector/opentsdb"
-	"github.com/bosun-monitor/scollector/util"
 )
 
 func init() {
@@ -53,7 +52,7 @@ func ntpUnPretty(s string) (int64, error) {
 func c_ntp_peers_unix() (opentsdb.MultiDataPoint, error) {
 	var md opentsdb.MultiDataPoint
 	const metric = "ntp."
-	util.ReadCommand(func(line string) error {
+	readCommand(func(line string) error {
 		fields := strings.Fields(line)
 		if len(fields) != len(ntpNtpqPeerFields) || fields[0] == "remote" {
 			return nil
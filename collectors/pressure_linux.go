@@ -0,0 +1,84 @@
+package collectors
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/bosun-monitor/scollector/metadata"
+	"github.com/bosun-monitor/scollector/opentsdb"
+)
+
+func init() {
+	collectors = append(collectors, &IntervalCollector{F: c_pressure_linux, Enable: pressureEnable})
+}
+
+// psiResources are the resources with Pressure Stall Information, from files
+// named after them in /proc/pressure and <resource>.pressure in cgroups.
+var psiResources = []string{"cpu", "memory", "io"}
+
+const (
+	descPSIAvg   = "Percentage of time in which some (kind=some) or all (kind=full) non-idle tasks were stalled waiting for the resource, averaged over the window in the metric name."
+	descPSITotal = "Total time in which some (kind=some) or all (kind=full) non-idle tasks were stalled waiting for the resource."
+)
+
+func pressureEnable() bool {
+	_, err := os.Stat(procPath("pressure"))
+	return err == nil
+}
+
+// c_pressure_linux reports the Pressure Stall Information of the system,
+// available since Linux 4.20 if enabled.
+func c_pressure_linux() (opentsdb.MultiDataPoint, error) {
+	var md opentsdb.MultiDataPoint
+	for _, r := range psiResources {
+		if err := addPressure(&md, "linux.pressure.", procPath("pressure", r), opentsdb.TagSet{"resource": r}); err != nil {
+			return nil, err
+		}
+	}
+	return md, nil
+}
+
+// addPressure adds the data points of the PSI file at path, whose lines are
+// of the form "some avg10=0.12 avg60=0.05 avg300=0.01 total=123456", with
+// total in microseconds. Metric names are prefix followed by avg10, avg60,
+// avg300 and total, and tagged with ts and kind. It is not an error for the
+// file not to exist.
+func addPressure(md *opentsdb.MultiDataPoint, prefix, path string, ts opentsdb.TagSet) error {
+	err := readLine(path, func(s string) error {
+		f := strings.Fields(s)
+		if len(f) == 0 {
+			return nil
+		}
+		tags := ts.Copy().Merge(opentsdb.TagSet{"kind": f[0]})
+		for _, kv := range f[1:] {
+			sp := strings.SplitN(kv, "=", 2)
+			if len(sp) != 2 {
+				continue
+			}
+			v, err := strconv.ParseFloat(sp[1], 64)
+			if err != nil {
+				return err
+			}
+			switch sp[0] {
+			case "avg10", "avg60", "avg300":
+				Add(md, prefix+sp[0], v, tags, metadata.Gauge, metadata.Pct, descPSIAvg)
+			case "total":
+				Add(md, prefix+"total", v/1e6, tags, metadata.Counter, metadata.Second, descPSITotal)
+			}
+		}
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// cgroupPressure adds the Pressure Stall Information of the cgroup v2 dir.
+func cgroupPressure(md *opentsdb.MultiDataPoint, dir string, ts opentsdb.TagSet) {
+	for _, r := range psiResources {
+		addPressure(md, "linux.cgroup.pressure.", filepath.Join(dir, r+".pressure"), ts.Copy().Merge(opentsdb.TagSet{"resource": r}))
+	}
+}
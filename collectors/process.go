@@ -44,6 +44,11 @@ type WatchedProc struct {
 	*idPool
 }
 
+// String returns w in the form given to NewWatchedProc.
+func (w *WatchedProc) String() string {
+	return w.Command + "," + w.Name + "," + w.ArgMatch.String()
+}
+
 // Check finds all matching processes and assigns them a new unique id.
 func (w *WatchedProc) Check(procs []*Process) {
 	for _, l := range procs {
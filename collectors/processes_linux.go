@@ -16,7 +16,8 @@ func WatchProcesses(procs []*WatchedProc) error {
 		F: func() (opentsdb.MultiDataPoint, error) {
 			return c_linux_processes(procs)
 		},
-		name: "c_linux_processes",
+		name:     "c_linux_processes",
+		settings: fmt.Sprint(procs),
 	})
 	return nil
 }
@@ -24,12 +25,12 @@ func WatchProcesses(procs []*WatchedProc) error {
 func linuxProcMonitor(w *WatchedProc, md *opentsdb.MultiDataPoint) error {
 	var err error
 	for pid, id := range w.Processes {
-		stats_file, e := ioutil.ReadFile("/proc/" + pid + "/stat")
+		stats_file, e := ioutil.ReadFile(procPath(pid, "stat"))
 		if e != nil {
 			w.Remove(pid)
 			continue
 		}
-		io_file, e := ioutil.ReadFile("/proc/" + pid + "/io")
+		io_file, e := ioutil.ReadFile(procPath(pid, "io"))
 		if e != nil {
 			w.Remove(pid)
 			continue
@@ -83,7 +84,7 @@ const (
 )
 
 func getLinuxProccesses() ([]*Process, error) {
-	files, err := ioutil.ReadDir("/proc")
+	files, err := ioutil.ReadDir(ProcRoot)
 	if err != nil {
 		return nil, err
 	}
@@ -95,7 +96,7 @@ func getLinuxProccesses() ([]*Process, error) {
 	}
 	var lps []*Process
 	for _, pid := range pids {
-		cmdline, err := ioutil.ReadFile("/proc/" + pid + "/cmdline")
+		cmdline, err := ioutil.ReadFile(procPath(pid, "cmdline"))
 		if err != nil {
 			//Continue because the pid might not exist any more
 			continue
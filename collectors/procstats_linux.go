@@ -3,6 +3,7 @@ package collectors
 import (
 	"fmt"
 	"io/ioutil"
+	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
@@ -39,7 +40,7 @@ var CPU_FIELDS = []string{
 func c_procstats_linux() (opentsdb.MultiDataPoint, error) {
 	var md opentsdb.MultiDataPoint
 	var Error error
-	if err := readLine("/proc/uptime", func(s string) error {
+	if err := readLine(procPath("uptime"), func(s string) error {
 		m := uptimeRE.FindStringSubmatch(s)
 		if m == nil {
 			return nil
@@ -52,7 +53,7 @@ func c_procstats_linux() (opentsdb.MultiDataPoint, error) {
 		Error = err
 	}
 	mem := make(map[string]float64)
-	if err := readLine("/proc/meminfo", func(s string) error {
+	if err := readLine(procPath("meminfo"), func(s string) error {
 		m := meminfoRE.FindStringSubmatch(s)
 		if m == nil {
 			return nil
@@ -73,7 +74,7 @@ func c_procstats_linux() (opentsdb.MultiDataPoint, error) {
 	if mem["MemTotal"] != 0 {
 		Add(&md, osMemPctFree, (mem["MemFree"]+mem["Buffers"]+mem["Cached"])/mem["MemTotal"]*100, nil, metadata.Gauge, metadata.Pct, osMemFreeDesc)
 	}
-	if err := readLine("/proc/vmstat", func(s string) error {
+	if err := readLine(procPath("vmstat"), func(s string) error {
 		m := vmstatRE.FindStringSubmatch(s)
 		if m == nil {
 			return nil
@@ -107,7 +108,7 @@ func c_procstats_linux() (opentsdb.MultiDataPoint, error) {
 		"guest":      "Running a guest vm.",
 		"guest_nice": "Running a niced guest vm.",
 	}
-	if err := readLine("/proc/stat", func(s string) error {
+	if err := readLine(procPath("stat"), func(s string) error {
 		m := statRE.FindStringSubmatch(s)
 		if m == nil {
 			return nil
@@ -169,7 +170,7 @@ func c_procstats_linux() (opentsdb.MultiDataPoint, error) {
 	if num_cores != 0 && t_util != 0 {
 		Add(&md, osCPU, t_util/float64(num_cores), nil, metadata.Gauge, metadata.Count, "")
 	}
-	if err := readLine("/proc/loadavg", func(s string) error {
+	if err := readLine(procPath("loadavg"), func(s string) error {
 		m := loadavgRE.FindStringSubmatch(s)
 		if m == nil {
 			return nil
@@ -183,7 +184,7 @@ func c_procstats_linux() (opentsdb.MultiDataPoint, error) {
 	}); err != nil {
 		Error = err
 	}
-	if err := readLine("/proc/sys/kernel/random/entropy_avail", func(s string) error {
+	if err := readLine(procPath("sys/kernel/random/entropy_avail"), func(s string) error {
 		Add(&md, "linux.entropy_avail", strings.TrimSpace(s), nil, metadata.Gauge, metadata.Entropy, "The remaing amount of entropy available to the system. If it is low or hitting zero processes might be blocked waiting for extropy")
 		return nil
 	}); err != nil {
@@ -204,7 +205,7 @@ func c_procstats_linux() (opentsdb.MultiDataPoint, error) {
 		"MCP": "Machine Check polls.",
 	}
 	num_cpus := 0
-	if err := readLine("/proc/interrupts", func(s string) error {
+	if err := readLine(procPath("interrupts"), func(s string) error {
 		cols := strings.Fields(s)
 		if num_cpus == 0 {
 			num_cpus = len(cols)
@@ -240,7 +241,7 @@ func c_procstats_linux() (opentsdb.MultiDataPoint, error) {
 	}); err != nil {
 		Error = err
 	}
-	if err := readLine("/proc/net/sockstat", func(s string) error {
+	if err := readLine(procPath("net/sockstat"), func(s string) error {
 		cols := strings.Fields(s)
 		switch cols[0] {
 		case "sockets:":
@@ -286,7 +287,7 @@ func c_procstats_linux() (opentsdb.MultiDataPoint, error) {
 	}
 	ln := 0
 	var headers []string
-	if err := readLine("/proc/net/netstat", func(s string) error {
+	if err := readLine(procPath("net/netstat"), func(s string) error {
 		cols := strings.Fields(s)
 		if ln%2 == 0 {
 			headers = cols
@@ -307,7 +308,7 @@ func c_procstats_linux() (opentsdb.MultiDataPoint, error) {
 		Error = err
 	}
 	ln = 0
-	if err := readLine("/proc/net/snmp", func(s string) error {
+	if err := readLine(procPath("net/snmp"), func(s string) error {
 		ln++
 		if ln%2 != 0 {
 			f := strings.Fields(s)
@@ -337,12 +338,12 @@ func c_procstats_linux() (opentsdb.MultiDataPoint, error) {
 	}); err != nil {
 		Error = err
 	}
-	const bondingPath = "/proc/net/bonding"
+	bondingPath := procPath("net/bonding")
 	bondDevices, _ := ioutil.ReadDir(bondingPath)
 	for _, fi := range bondDevices {
 		var iface string
 		var slave_count int
-		if err := readLine(bondingPath+"/"+fi.Name(), func(s string) error {
+		if err := readLine(filepath.Join(bondingPath, fi.Name()), func(s string) error {
 			f := strings.SplitN(s, ":", 2)
 			if len(f) != 2 {
 				return nil
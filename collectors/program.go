@@ -2,6 +2,8 @@ package collectors
 
 import (
 	"bufio"
+	"context"
+	"fmt"
 	"io"
 	"os"
 	"os/exec"
@@ -9,6 +11,7 @@ import (
 	"runtime"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/StackExchange/slog"
@@ -17,8 +20,15 @@ import (
 )
 
 type ProgramCollector struct {
+	// sent counts the data points sent since they were last recorded, for
+	// continuous programs. It is first for 64-bit alignment of atomic access.
+	sent int64
+
 	Path     string
 	Interval time.Duration
+
+	stats runStats
+	now   trigger
 }
 
 func InitPrograms(cpath string) {
@@ -61,6 +71,15 @@ func InitPrograms(cpath string) {
 	}
 }
 
+// AddProgram registers the external collector at path. If interval is 0, the
+// program is expected to run continuously and is restarted if it exits.
+func AddProgram(path string, interval time.Duration) {
+	collectors = append(collectors, &ProgramCollector{
+		Path:     path,
+		Interval: interval,
+	})
+}
+
 func isExecutable(f os.FileInfo) bool {
 	switch runtime.GOOS {
 	case "windows":
@@ -77,37 +96,92 @@ func isExecutable(f os.FileInfo) bool {
 	}
 }
 
-func (c *ProgramCollector) Run(dpchan chan<- *opentsdb.DataPoint) {
+// Run runs the program continuously or at its interval. A running program is
+// killed when the collector is stopped.
+func (c *ProgramCollector) Run(ctx context.Context, dpchan chan<- *opentsdb.DataPoint) {
+	defer c.stats.stop(c.Name())
 	if c.Interval == 0 {
+		recorded := make(chan struct{})
+		go func() {
+			defer close(recorded)
+			c.recordContinuous(ctx)
+		}()
+		defer func() { <-recorded }()
 		for {
 			next := time.After(DefaultFreq)
-			if err := c.runProgram(dpchan); err != nil {
-				slog.Infoln(err)
+			start := time.Now()
+			_, err := c.runProgram(exec.CommandContext(ctx, c.Path), dpchan)
+			if ctx.Err() == nil {
+				c.stats.record(c.Name(), start, int(atomic.SwapInt64(&c.sent, 0)), err)
+				if err != nil {
+					slog.Infoln(err)
+				}
+			}
+			select {
+			case <-next:
+			case <-ctx.Done():
+				return
 			}
-			<-next
 			slog.Infoln("restarting", c.Path)
 		}
 	} else {
+		now := c.now.channel()
 		for {
 			next := time.After(c.Interval)
-			c.runProgram(dpchan)
-			<-next
+			start := time.Now()
+			n, err := c.runProgram(exec.CommandContext(ctx, c.Path), dpchan)
+			if ctx.Err() == nil {
+				c.stats.record(c.Name(), start, n, err)
+			}
+			select {
+			case <-next:
+			case <-now:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// recordContinuous records the data points sent by a continuous program every
+// DefaultFreq while it runs, as it may never exit.
+func (c *ProgramCollector) recordContinuous(ctx context.Context) {
+	t := time.NewTicker(DefaultFreq)
+	defer t.Stop()
+	last := time.Now()
+	for {
+		select {
+		case now := <-t.C:
+			c.stats.record(c.Name(), last, int(atomic.SwapInt64(&c.sent, 0)), nil)
+			last = now
+		case <-ctx.Done():
+			return
 		}
 	}
 }
 
+func (c *ProgramCollector) runNow() bool {
+	if c.Interval == 0 {
+		return false
+	}
+	c.now.fire()
+	return true
+}
+
 func (c *ProgramCollector) Init() {
 }
 
-func (c *ProgramCollector) runProgram(dpchan chan<- *opentsdb.DataPoint) (progError error) {
-	cmd := exec.Command(c.Path)
+// runProgram runs cmd and sends the data points it outputs. It returns the
+// number of data points sent and the exit error of the program or, if it
+// exited successfully, the first invalid line.
+func (c *ProgramCollector) runProgram(cmd *exec.Cmd, dpchan chan<- *opentsdb.DataPoint) (n int, progError error) {
 	pr, pw := io.Pipe()
 	s := bufio.NewScanner(pr)
 	cmd.Stdout = pw
 	er, ew := io.Pipe()
 	cmd.Stderr = ew
 	if err := cmd.Start(); err != nil {
-		return err
+		return 0, err
 	}
 	go func() {
 		progError = cmd.Wait()
@@ -121,52 +195,69 @@ func (c *ProgramCollector) runProgram(dpchan chan<- *opentsdb.DataPoint) (progEr
 			slog.Error(line)
 		}
 	}()
-Loop:
+	var lineError error
 	for s.Scan() {
-		line := strings.TrimSpace(s.Text())
-		sp := strings.Fields(line)
-		if len(sp) < 3 {
-			slog.Errorf("bad line in program %s: %s", c.Path, line)
-			continue
-		}
-		ts, err := strconv.ParseInt(sp[1], 10, 64)
+		dp, err := parseTcollectorValue(s.Text())
 		if err != nil {
-			slog.Errorf("bad timestamp in program %s: %s", c.Path, sp[1])
-			continue
-		}
-		val, err := strconv.ParseFloat(sp[2], 64)
-		if err != nil {
-			slog.Errorf("bad value in program %s: %s", c.Path, sp[2])
+			slog.Errorf("program %s: %v", c.Path, err)
+			if lineError == nil {
+				lineError = err
+			}
 			continue
 		}
-		if !opentsdb.ValidTag(sp[0]) {
-			slog.Errorf("bad metric in program %s: %s", c.Path, sp[0])
-		}
-		dp := opentsdb.DataPoint{
-			Metric:    sp[0],
-			Timestamp: ts,
-			Value:     val,
-			Tags:      opentsdb.TagSet{"host": util.Hostname},
+		if !opentsdb.ValidTag(dp.Metric) {
+			// Sent anyway, as external collectors always were.
+			slog.Errorf("program %s: bad metric: %s", c.Path, dp.Metric)
 		}
-		for _, tag := range sp[3:] {
-			tags, err := opentsdb.ParseTags(tag)
-			if v, ok := tags["host"]; ok && v == "" {
-				delete(dp.Tags, "host")
-			} else if err != nil {
-				slog.Errorf("bad tag in program %s, metric %s: %v: %v", c.Path, sp[0], tag, err)
-				continue Loop
-			} else {
-				dp.Tags.Merge(tags)
-			}
-		}
-		dpchan <- &dp
+		dpchan <- dp
+		atomic.AddInt64(&c.sent, 1)
+		n++
 	}
 	if err := s.Err(); err != nil {
-		return err
+		return n, err
+	}
+	if progError == nil {
+		progError = lineError
 	}
 	return
 }
 
+// parseTcollectorValue parses a line of the form "metric timestamp value
+// tag1=val1 tag2=val2 ...". A host tag is added unless given; an empty host
+// tag removes it. The metric name is not validated.
+func parseTcollectorValue(line string) (*opentsdb.DataPoint, error) {
+	line = strings.TrimSpace(line)
+	sp := strings.Fields(line)
+	if len(sp) < 3 {
+		return nil, fmt.Errorf("bad line: %s", line)
+	}
+	ts, err := strconv.ParseInt(sp[1], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("bad timestamp: %s", sp[1])
+	}
+	val, err := strconv.ParseFloat(sp[2], 64)
+	if err != nil {
+		return nil, fmt.Errorf("bad value: %s", sp[2])
+	}
+	dp := opentsdb.DataPoint{
+		Metric:    sp[0],
+		Timestamp: ts,
+		Value:     val,
+		Tags:      opentsdb.TagSet{"host": util.Hostname},
+	}
+	for _, tag := range sp[3:] {
+		tags, err := opentsdb.ParseTags(tag)
+		if v, ok := tags["host"]; ok && v == "" {
+			delete(dp.Tags, "host")
+		} else if err != nil {
+			return nil, fmt.Errorf("bad tag, metric %s: %v: %v", sp[0], tag, err)
+		} else {
+			dp.Tags.Merge(tags)
+		}
+	}
+	return &dp, nil
+}
+
 func (c *ProgramCollector) Name() string {
 	return c.Path
 }
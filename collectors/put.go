@@ -0,0 +1,148 @@
+package collectors
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/StackExchange/slog"
+	"github.com/bosun-monitor/scollector/opentsdb"
+)
+
+// PutListener is a collector that accepts OpenTSDB telnet-style lines of the
+// form "put metric timestamp value tag1=val1 ..." over TCP and UDP, as sent by
+// applications written for tcollector or OpenTSDB.
+type PutListener struct {
+	Addr string
+}
+
+// ListenPut registers a PutListener on addr (ex: ":4243").
+func ListenPut(addr string) {
+	collectors = append(collectors, &PutListener{Addr: addr})
+}
+
+func (p *PutListener) Init() {
+}
+
+func (p *PutListener) Name() string {
+	return "put-" + p.Addr
+}
+
+// Run serves until ctx is done, then closes the accepted connections and
+// returns once they are no longer read.
+func (p *PutListener) Run(ctx context.Context, dpchan chan<- *opentsdb.DataPoint) {
+	l, err := net.Listen("tcp", p.Addr)
+	if err != nil {
+		slog.Errorf("%v: %v", p.Name(), err)
+		return
+	}
+	pc, err := net.ListenPacket("udp", p.Addr)
+	if err != nil {
+		slog.Errorf("%v: %v", p.Name(), err)
+		l.Close()
+		return
+	}
+	var (
+		wg    sync.WaitGroup
+		mu    sync.Mutex
+		conns = make(map[net.Conn]bool) // nil once stopped
+	)
+	defer wg.Wait()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		<-ctx.Done()
+		l.Close()
+		pc.Close()
+		mu.Lock()
+		for c := range conns {
+			c.Close()
+		}
+		conns = nil
+		mu.Unlock()
+	}()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		p.serveUDP(ctx, pc, dpchan)
+	}()
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			if ctx.Err() == nil {
+				slog.Errorf("%v: %v", p.Name(), err)
+			}
+			return
+		}
+		mu.Lock()
+		if conns == nil {
+			mu.Unlock()
+			c.Close()
+			continue
+		}
+		conns[c] = true
+		mu.Unlock()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.serveTCP(c, dpchan)
+			mu.Lock()
+			delete(conns, c)
+			mu.Unlock()
+		}()
+	}
+}
+
+func (p *PutListener) serveTCP(c net.Conn, dpchan chan<- *opentsdb.DataPoint) {
+	defer c.Close()
+	s := bufio.NewScanner(c)
+	for s.Scan() {
+		switch cmd := strings.TrimSpace(s.Text()); cmd {
+		case "version":
+			// tcollector checks its connection with a version request.
+			io.WriteString(c, "scollector\n")
+		case "exit":
+			return
+		default:
+			p.put(cmd, dpchan)
+		}
+	}
+}
+
+func (p *PutListener) serveUDP(ctx context.Context, pc net.PacketConn, dpchan chan<- *opentsdb.DataPoint) {
+	buf := make([]byte, 65536)
+	for {
+		n, _, err := pc.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() == nil {
+				slog.Errorf("%v: %v", p.Name(), err)
+			}
+			return
+		}
+		for _, line := range strings.Split(string(buf[:n]), "\n") {
+			p.put(line, dpchan)
+		}
+	}
+}
+
+// put parses a put line and sends the resulting data point, unless its metric
+// name is invalid. Other commands are ignored.
+func (p *PutListener) put(line string, dpchan chan<- *opentsdb.DataPoint) {
+	line = strings.TrimSpace(line)
+	if !strings.HasPrefix(line, "put ") {
+		return
+	}
+	dp, err := parseTcollectorValue(line[4:])
+	if err == nil && !opentsdb.ValidTag(dp.Metric) {
+		err = fmt.Errorf("bad metric: %s", dp.Metric)
+	}
+	if err != nil {
+		slog.Errorf("%v: %v", p.Name(), err)
+		return
+	}
+	dpchan <- dp
+}
@@ -9,7 +9,6 @@ import (
 
 	"github.com/bosun-monitor/scollector/metadata"
 	"github.com/bosun-monitor/scollector/opentsdb"
-	"github.com/bosun-monitor/scollector/util"
 )
 
 func init() {
@@ -24,7 +23,7 @@ var (
 func parseRailURL() string {
 	var config string
 	var url string
-	util.ReadCommand(func(line string) error {
+	readCommand(func(line string) error {
 		fields := strings.Fields(line)
 		if len(fields) == 0 || !strings.Contains(fields[0], "rg-listener") {
 			return nil
@@ -14,7 +14,6 @@ import (
 
 	"github.com/bosun-monitor/scollector/metadata"
 	"github.com/bosun-monitor/scollector/opentsdb"
-	"github.com/bosun-monitor/scollector/util"
 )
 
 func init() {
@@ -102,7 +101,7 @@ func redisInit() {
 			defer func() {
 				ri[port] = cluster
 			}()
-			f, err := ioutil.ReadFile(fmt.Sprintf("/proc/%s/cmdline", pid))
+			f, err := ioutil.ReadFile(procPath(pid, "cmdline"))
 			if err != nil {
 				return
 			}
@@ -122,7 +121,7 @@ func redisInit() {
 				return nil
 			})
 		}
-		util.ReadCommand(func(line string) error {
+		readCommand(func(line string) error {
 			sp := strings.Fields(line)
 			if len(sp) != 3 || !strings.HasSuffix(sp[1], "redis-server") {
 				return nil
@@ -137,7 +136,7 @@ func redisInit() {
 			return nil
 		}, "ps", "-e", "-o", "pid,args")
 		if oldRedis {
-			util.ReadCommand(func(line string) error {
+			readCommand(func(line string) error {
 				if !strings.Contains(line, "redis-server") {
 					return nil
 				}
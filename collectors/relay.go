@@ -0,0 +1,195 @@
+package collectors
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/StackExchange/slog"
+	"github.com/bosun-monitor/scollector/opentsdb"
+	"github.com/bosun-monitor/scollector/util"
+)
+
+// maxRelayBody is the largest request body accepted by a RelayListener.
+const maxRelayBody = 32 << 20
+
+// relayShutdownTimeout is how long a stopped RelayListener lets the requests
+// being served finish.
+const relayShutdownTimeout = time.Second * 5
+
+// RelayListener is a collector that serves an OpenTSDB compatible /api/put
+// endpoint. Posted data points are sent along with scollector's own data.
+type RelayListener struct {
+	Addr string
+
+	dpchan chan<- *opentsdb.DataPoint
+}
+
+// ListenRelay registers a RelayListener on addr (ex: ":4242").
+func ListenRelay(addr string) {
+	collectors = append(collectors, &RelayListener{Addr: addr})
+}
+
+func (r *RelayListener) Init() {
+}
+
+func (r *RelayListener) Name() string {
+	return "relay-" + r.Addr
+}
+
+// Run serves until ctx is done, then lets the requests being served finish for
+// up to relayShutdownTimeout and closes all connections before returning.
+func (r *RelayListener) Run(ctx context.Context, dpchan chan<- *opentsdb.DataPoint) {
+	r.dpchan = dpchan
+	l, err := net.Listen("tcp", r.Addr)
+	if err != nil {
+		slog.Errorf("%v: %v", r.Name(), err)
+		return
+	}
+	var (
+		handlers sync.WaitGroup
+		mu       sync.Mutex
+		stopped  bool
+	)
+	mux := http.NewServeMux()
+	mux.Handle("/api/put", r)
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		if stopped {
+			mu.Unlock()
+			http.Error(w, "relay stopped", http.StatusServiceUnavailable)
+			return
+		}
+		handlers.Add(1)
+		mu.Unlock()
+		defer handlers.Done()
+		mux.ServeHTTP(w, req)
+	})}
+	ctx, cancel := context.WithCancel(ctx)
+	closed := make(chan struct{})
+	go func() {
+		<-ctx.Done()
+		sctx, scancel := context.WithTimeout(context.Background(), relayShutdownTimeout)
+		srv.Shutdown(sctx)
+		scancel()
+		srv.Close()
+		close(closed)
+	}()
+	if err := srv.Serve(l); ctx.Err() == nil {
+		slog.Errorf("%v: %v", r.Name(), err)
+	}
+	cancel()
+	<-closed
+	mu.Lock()
+	stopped = true
+	mu.Unlock()
+	handlers.Wait()
+}
+
+// ServeHTTP accepts a JSON data point or array of data points, optionally
+// gzipped. Like OpenTSDB, valid data points are kept even if others in the same
+// request are bad, in which case the errors are returned with status 400.
+func (r *RelayListener) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var body io.Reader = http.MaxBytesReader(w, req.Body, maxRelayBody)
+	if req.Header.Get("Content-Encoding") == "gzip" {
+		g, err := gzip.NewReader(body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer g.Close()
+		body = g
+	}
+	md, err := decodeDataPoints(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var errs bytes.Buffer
+	for _, dp := range md {
+		if err := relayCheck(dp); err != nil {
+			fmt.Fprintln(&errs, err)
+			continue
+		}
+		r.dpchan <- dp
+	}
+	if errs.Len() > 0 {
+		http.Error(w, errs.String(), http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// decodeDataPoints decodes either a single data point or an array of them.
+// Numbers are kept as json.Number so large integers are not rounded.
+func decodeDataPoints(r io.Reader) (opentsdb.MultiDataPoint, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	b = bytes.TrimSpace(b)
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	if len(b) > 0 && b[0] == '[' {
+		var md opentsdb.MultiDataPoint
+		err := d.Decode(&md)
+		return md, err
+	}
+	var dp opentsdb.DataPoint
+	if err := d.Decode(&dp); err != nil {
+		return nil, err
+	}
+	return opentsdb.MultiDataPoint{&dp}, nil
+}
+
+// relayCheck validates dp and adds the host tag if it is missing. An empty host
+// tag removes it.
+func relayCheck(dp *opentsdb.DataPoint) error {
+	if dp == nil {
+		return fmt.Errorf("null data point")
+	}
+	if !opentsdb.ValidTag(dp.Metric) {
+		return fmt.Errorf("bad metric: %q", dp.Metric)
+	}
+	if dp.Timestamp <= 0 {
+		return fmt.Errorf("%s: bad timestamp: %d", dp.Metric, dp.Timestamp)
+	}
+	switch v := dp.Value.(type) {
+	case json.Number:
+		if _, err := v.Float64(); err != nil {
+			return fmt.Errorf("%s: bad value: %v", dp.Metric, v)
+		}
+	default:
+		return fmt.Errorf("%s: bad value: %v", dp.Metric, v)
+	}
+	if dp.Tags == nil {
+		dp.Tags = make(opentsdb.TagSet)
+	}
+	for k, v := range dp.Tags {
+		if k == "host" && v == "" {
+			continue
+		}
+		if !opentsdb.ValidTag(k) || !opentsdb.ValidTag(v) {
+			return fmt.Errorf("%s: bad tag: %s=%s", dp.Metric, k, v)
+		}
+	}
+	if host, present := dp.Tags["host"]; !present {
+		dp.Tags["host"] = util.Hostname
+	} else if host == "" {
+		delete(dp.Tags, "host")
+	}
+	return nil
+}
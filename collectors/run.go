@@ -0,0 +1,477 @@
+package collectors
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/StackExchange/slog"
+	"github.com/bosun-monitor/scollector/opentsdb"
+)
+
+// A Handle controls the collectors started by Run. Collectors are identified
+// by name.
+type Handle struct {
+	// C receives the data points of all collectors.
+	C chan *opentsdb.DataPoint
+
+	sync.Mutex // protects running, disabled, stopping, pending and inits
+	running    map[string]*runningCollector
+	disabled   map[string]Collector
+	// stopping holds the done channels of stopped collectors, so that a
+	// collector is not started again before its previous run returned and
+	// released resources such as listening sockets.
+	stopping map[string]<-chan struct{}
+	// pending holds the collectors to start once their previous run
+	// returned.
+	pending map[string]Collector
+	// inits runs the Init method of each collector instance once, however
+	// many times it is started.
+	inits map[Collector]*sync.Once
+	wg    sync.WaitGroup
+}
+
+type runningCollector struct {
+	Collector
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// Runs specified collectors. Use nil for all collectors.
+func Run(cs []Collector) *Handle {
+	if cs == nil {
+		cs = collectors
+	}
+	h := &Handle{
+		C:        make(chan *opentsdb.DataPoint),
+		running:  make(map[string]*runningCollector),
+		disabled: make(map[string]Collector),
+		stopping: make(map[string]<-chan struct{}),
+		pending:  make(map[string]Collector),
+		inits:    make(map[Collector]*sync.Once),
+	}
+	h.Update(cs)
+	return h
+}
+
+// Update changes the running collectors to cs. Those no longer in cs are
+// stopped, new ones are started, and those whose interval or settings changed
+// are restarted once their previous instance returned. The Init method of a
+// collector runs once, before it first runs, even if it is restarted. The
+// data points the replaced collectors send while finishing must be received
+// from C for them to do so. Update waits for them up to StopTimeout; those
+// still running then are restarted later, when they return. Collectors
+// disabled with Disable stay disabled.
+func (h *Handle) Update(cs []Collector) {
+	h.Lock()
+	next := make(map[string]Collector)
+	for _, c := range cs {
+		if _, present := next[c.Name()]; present {
+			slog.Errorf("duplicate collector %s not run", c.Name())
+			continue
+		}
+		next[c.Name()] = c
+	}
+	for name, r := range h.running {
+		if c, present := next[name]; present && sameSettings(r.Collector, c) {
+			continue
+		}
+		h.stop(name)
+	}
+	for name := range h.disabled {
+		if _, present := next[name]; !present {
+			delete(h.disabled, name)
+		}
+	}
+	for name := range h.pending {
+		if _, present := next[name]; !present {
+			delete(h.pending, name)
+		}
+	}
+	for c := range h.inits {
+		if next[c.Name()] != c {
+			delete(h.inits, c)
+		}
+	}
+	var start []Collector
+	for name, c := range next {
+		if _, disabled := h.disabled[name]; disabled {
+			h.disabled[name] = c
+		} else if _, present := h.running[name]; !present {
+			start = append(start, c)
+		}
+	}
+	h.Unlock()
+	h.startStopped(start)
+}
+
+// startStopped starts cs once the previous run of each, if stopping, has
+// returned. It waits up to StopTimeout; collectors whose previous run has not
+// returned by then are started when it does, unless replaced, disabled or
+// removed meanwhile.
+func (h *Handle) startStopped(cs []Collector) {
+	h.Lock()
+	wait := make(map[string]<-chan struct{})
+	for _, c := range cs {
+		h.pending[c.Name()] = c
+		if done := h.stopping[c.Name()]; done != nil {
+			wait[c.Name()] = done
+		}
+	}
+	h.Unlock()
+	timeout := time.NewTimer(StopTimeout)
+	defer timeout.Stop()
+	expired := false
+	late := make(map[string]bool)
+	for name, done := range wait {
+		if !expired {
+			select {
+			case <-done:
+				continue
+			case <-timeout.C:
+				expired = true
+			}
+		}
+		select {
+		case <-done:
+			continue
+		default:
+		}
+		slog.Errorf("%s did not return within %v; starting it once it does", name, StopTimeout)
+		late[name] = true
+		go func(name string, done <-chan struct{}) {
+			<-done
+			h.Lock()
+			defer h.Unlock()
+			h.startPending(name)
+		}(name, done)
+	}
+	h.Lock()
+	defer h.Unlock()
+	for _, c := range cs {
+		if !late[c.Name()] {
+			h.startPending(c.Name())
+		}
+	}
+}
+
+// startPending starts the pending collector of that name, if it is neither
+// running nor disabled.
+func (h *Handle) startPending(name string) {
+	c := h.pending[name]
+	if c == nil {
+		return
+	}
+	delete(h.pending, name)
+	_, disabled := h.disabled[name]
+	if _, present := h.running[name]; !present && !disabled {
+		h.start(c)
+	}
+}
+
+func (h *Handle) start(c Collector) {
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &runningCollector{c, cancel, make(chan struct{})}
+	h.running[c.Name()] = r
+	delete(h.stopping, c.Name())
+	once := h.inits[c]
+	if once == nil {
+		once = new(sync.Once)
+		h.inits[c] = once
+	}
+	h.wg.Add(1)
+	go func() {
+		defer h.wg.Done()
+		defer close(r.done)
+		once.Do(c.Init)
+		c.Run(ctx, h.C)
+	}()
+}
+
+func (h *Handle) stop(name string) <-chan struct{} {
+	r := h.running[name]
+	if r == nil {
+		return nil
+	}
+	slog.Infoln("stopping", name)
+	r.cancel()
+	delete(h.running, name)
+	for n, done := range h.stopping {
+		select {
+		case <-done:
+			delete(h.stopping, n)
+		default:
+		}
+	}
+	h.stopping[name] = r.done
+	return r.done
+}
+
+// Stop stops the named collector. The returned channel is closed once it has
+// returned. It is nil if no such collector is running.
+func (h *Handle) Stop(name string) <-chan struct{} {
+	h.Lock()
+	defer h.Unlock()
+	return h.stop(name)
+}
+
+// StopAll stops all collectors. Use Wait to wait for them to return.
+func (h *Handle) StopAll() {
+	h.Lock()
+	defer h.Unlock()
+	for name := range h.running {
+		h.stop(name)
+	}
+}
+
+// Wait waits for all collectors to return, once stopped by StopAll. The data
+// points they send while finishing must be received from C for them to do so.
+func (h *Handle) Wait() {
+	h.wg.Wait()
+}
+
+// Running returns the names of the running collectors, sorted.
+func (h *Handle) Running() []string {
+	h.Lock()
+	defer h.Unlock()
+	names := make([]string, 0, len(h.running))
+	for name := range h.running {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Stopping returns the names of the stopped collectors that have not returned
+// yet, sorted.
+func (h *Handle) Stopping() []string {
+	h.Lock()
+	defer h.Unlock()
+	var names []string
+	for name, done := range h.stopping {
+		select {
+		case <-done:
+		default:
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Disable stops the named collector until Enable is called. The returned
+// channel is closed once it has returned.
+func (h *Handle) Disable(name string) (<-chan struct{}, error) {
+	h.Lock()
+	defer h.Unlock()
+	r := h.running[name]
+	if r == nil {
+		return nil, h.notRunning(name)
+	}
+	h.disabled[name] = r.Collector
+	slog.Infoln("disabling", name)
+	return h.stop(name), nil
+}
+
+// Enable starts the named collector, disabled by Disable, once it returned. It
+// waits for that as Update does.
+func (h *Handle) Enable(name string) error {
+	h.Lock()
+	c := h.disabled[name]
+	if c == nil {
+		defer h.Unlock()
+		if h.running[name] != nil {
+			return fmt.Errorf("%s is already enabled", name)
+		}
+		return fmt.Errorf("no collector named %s", name)
+	}
+	delete(h.disabled, name)
+	h.Unlock()
+	slog.Infoln("enabling", name)
+	h.startStopped([]Collector{c})
+	return nil
+}
+
+// RunNow asks the named collector to collect immediately instead of waiting
+// for its next interval. Only interval collectors and external collectors run
+// at an interval support it.
+func (h *Handle) RunNow(name string) error {
+	h.Lock()
+	defer h.Unlock()
+	r := h.running[name]
+	if r == nil {
+		return h.notRunning(name)
+	}
+	if t, ok := r.Collector.(interface {
+		runNow() bool
+	}); !ok || !t.runNow() {
+		return fmt.Errorf("%s does not run at an interval", name)
+	}
+	return nil
+}
+
+func (h *Handle) notRunning(name string) error {
+	if h.disabled[name] != nil {
+		return fmt.Errorf("%s is disabled", name)
+	}
+	return fmt.Errorf("no collector named %s", name)
+}
+
+// Status describes a collector and the outcome of its last run. The run
+// fields are only set for interval and external collectors.
+type Status struct {
+	Name string `json:"name"`
+	// Disabled is set if the collector was disabled with Handle.Disable.
+	Disabled bool `json:"disabled"`
+	// Enabled is false if the collector is an IntervalCollector whose Enable
+	// function reports it should not currently run.
+	Enabled     bool      `json:"enabled"`
+	LastRun     time.Time `json:"last_run"`
+	LastSuccess time.Time `json:"last_success"`
+	LastError   string    `json:"last_error,omitempty"`
+	Duration    float64   `json:"duration"` // seconds
+	DataPoints  int       `json:"datapoints"`
+}
+
+// Status returns the status of the running and disabled collectors, sorted
+// by name.
+func (h *Handle) Status() []Status {
+	h.Lock()
+	var sts []Status
+	for name, r := range h.running {
+		sts = append(sts, collectorStatus(name, r.Collector, false))
+	}
+	for name, c := range h.disabled {
+		sts = append(sts, collectorStatus(name, c, true))
+	}
+	h.Unlock()
+	sort.Sort(byName(sts))
+	return sts
+}
+
+func collectorStatus(name string, c Collector, disabled bool) Status {
+	st := Status{Name: name, Disabled: disabled, Enabled: true}
+	var s *runStats
+	switch c := c.(type) {
+	case *IntervalCollector:
+		st.Enabled = c.Enabled()
+		s = &c.stats
+	case *ProgramCollector:
+		s = &c.stats
+	default:
+		return st
+	}
+	s.Lock()
+	st.LastRun = s.lastRun
+	st.LastSuccess = s.lastSuccess
+	if s.lastErr != nil {
+		st.LastError = s.lastErr.Error()
+	}
+	st.Duration = s.duration.Seconds()
+	st.DataPoints = s.datapoints
+	s.Unlock()
+	return st
+}
+
+type byName []Status
+
+func (b byName) Len() int           { return len(b) }
+func (b byName) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byName) Less(i, j int) bool { return b[i].Name < b[j].Name }
+
+// trigger signals a collector to run before its next interval.
+type trigger struct {
+	sync.Mutex
+	c chan struct{}
+}
+
+func (t *trigger) get() chan struct{} {
+	t.Lock()
+	defer t.Unlock()
+	if t.c == nil {
+		t.c = make(chan struct{}, 1)
+	}
+	return t.c
+}
+
+// channel returns the channel that receives a value when fire is called.
+func (t *trigger) channel() <-chan struct{} {
+	return t.get()
+}
+
+// fire signals the collector. Calls made before it has received the signal
+// are merged.
+func (t *trigger) fire() {
+	select {
+	case t.get() <- struct{}{}:
+	default:
+	}
+}
+
+var (
+	// ErrNotEnabled is returned by Once for a collector whose Enable function
+	// reports it should not run on this host.
+	ErrNotEnabled = errors.New("not enabled")
+
+	// ErrContinuous is returned by Once for a collector that does not run at
+	// an interval, such as a listener.
+	ErrContinuous = errors.New("does not run at an interval")
+)
+
+// Once initializes and runs c a single time, and returns the data points it
+// produced. Only interval collectors and external collectors run at an
+// interval support it. Runs are subject to DefaultTimeout.
+func Once(c Collector) (opentsdb.MultiDataPoint, error) {
+	switch c := c.(type) {
+	case *IntervalCollector:
+		c.Init()
+		if c.Enable != nil && !c.Enable() {
+			return nil, ErrNotEnabled
+		}
+		return c.call()
+	case *ProgramCollector:
+		if c.Interval == 0 {
+			break
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), DefaultTimeout)
+		defer cancel()
+		ch := make(chan *opentsdb.DataPoint)
+		done := make(chan opentsdb.MultiDataPoint)
+		go func() {
+			var md opentsdb.MultiDataPoint
+			for dp := range ch {
+				md = append(md, dp)
+			}
+			done <- md
+		}()
+		_, err := c.runProgram(exec.CommandContext(ctx, c.Path), ch)
+		close(ch)
+		md := <-done
+		if ctx.Err() != nil {
+			err = fmt.Errorf("timed out after %v", DefaultTimeout)
+		}
+		return md, err
+	}
+	return nil, ErrContinuous
+}
+
+// sameSettings reports whether collectors a and b, of the same name, have the
+// same interval and settings.
+func sameSettings(a, b Collector) bool {
+	switch a := a.(type) {
+	case *IntervalCollector:
+		b, ok := b.(*IntervalCollector)
+		return ok && a.Interval == b.Interval && a.settings == b.settings
+	case *ProgramCollector:
+		b, ok := b.(*ProgramCollector)
+		return ok && a.Interval == b.Interval
+	case *StatsDListener:
+		b, ok := b.(*StatsDListener)
+		return ok && a.Freq == b.Freq
+	}
+	return true
+}
@@ -0,0 +1,400 @@
+package collectors
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bosun-monitor/scollector/metadata"
+	"github.com/bosun-monitor/scollector/opentsdb"
+)
+
+func testCollector(name string, interval time.Duration) *IntervalCollector {
+	return &IntervalCollector{
+		F: func() (opentsdb.MultiDataPoint, error) {
+			var md opentsdb.MultiDataPoint
+			Add(&md, "test."+name, 1, nil, metadata.Unknown, metadata.None, "")
+			return md, nil
+		},
+		Interval: interval,
+		name:     name,
+	}
+}
+
+// receive returns the metrics of the data points received from h within d.
+func receive(h *Handle, d time.Duration) map[string]int {
+	got := make(map[string]int)
+	timeout := time.After(d)
+	for {
+		select {
+		case dp := <-h.C:
+			got[dp.Metric]++
+		case <-timeout:
+			return got
+		}
+	}
+}
+
+// updateReceiving calls h.Update, receiving from h.C meanwhile.
+func updateReceiving(h *Handle, cs []Collector) {
+	done := make(chan struct{})
+	go func() {
+		h.Update(cs)
+		close(done)
+	}()
+	for {
+		select {
+		case <-h.C:
+		case <-done:
+			return
+		}
+	}
+}
+
+func TestHandle(t *testing.T) {
+	a := testCollector("a", 10*time.Millisecond)
+	h := Run([]Collector{a, testCollector("b", 10*time.Millisecond)})
+	if got := receive(h, 100*time.Millisecond); got["test.a"] == 0 || got["test.b"] == 0 {
+		t.Fatalf("expected data from both collectors, got %v", got)
+	}
+	done := h.Stop("b")
+	if done == nil {
+		t.Fatal("b not running")
+	}
+	receive(h, 20*time.Millisecond)
+	select {
+	case <-done:
+	default:
+		t.Fatal("b did not return")
+	}
+	if got := receive(h, 50*time.Millisecond); got["test.b"] != 0 {
+		t.Errorf("stopped collector sent %d data points", got["test.b"])
+	}
+	if h.Stop("b") != nil {
+		t.Error("b still running")
+	}
+
+	// An unchanged collector keeps running; a changed interval restarts it.
+	updateReceiving(h, []Collector{testCollector("a", 10*time.Millisecond), testCollector("c", time.Hour)})
+	h.Lock()
+	kept := h.running["a"].Collector == a
+	h.Unlock()
+	if !kept {
+		t.Error("unchanged collector was restarted")
+	}
+	updateReceiving(h, []Collector{testCollector("a", 20*time.Millisecond)})
+	h.Lock()
+	kept = h.running["a"].Collector == a
+	h.Unlock()
+	if kept {
+		t.Error("changed collector was not restarted")
+	}
+	if names := h.Running(); len(names) != 1 || names[0] != "a" {
+		t.Errorf("unexpected running collectors: %v", names)
+	}
+
+	h.StopAll()
+	waited := make(chan struct{})
+	go func() {
+		h.Wait()
+		close(waited)
+	}()
+	for {
+		select {
+		case <-h.C:
+			continue
+		case <-waited:
+		case <-time.After(time.Second):
+			t.Fatal("collectors did not return")
+		}
+		break
+	}
+}
+
+// blockingCollector ignores cancellation until release is closed.
+type blockingCollector struct {
+	release chan struct{}
+}
+
+func (c *blockingCollector) Init()        {}
+func (c *blockingCollector) Name() string { return "blocking" }
+func (c *blockingCollector) Run(ctx context.Context, dpchan chan<- *opentsdb.DataPoint) {
+	<-c.release
+}
+
+func TestStopping(t *testing.T) {
+	c := &blockingCollector{make(chan struct{})}
+	h := Run([]Collector{c, testCollector("a", time.Hour)})
+	go func() {
+		for range h.C {
+		}
+	}()
+	h.StopAll()
+	timeout := time.After(time.Second)
+	for {
+		names := h.Stopping()
+		if len(names) == 1 && names[0] == "blocking" {
+			break
+		}
+		select {
+		case <-timeout:
+			t.Fatalf("expected blocking to be stopping, got %v", names)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	close(c.release)
+	h.Wait()
+	if names := h.Stopping(); len(names) != 0 {
+		t.Errorf("expected no collector stopping, got %v", names)
+	}
+}
+
+// TestEnableLate enables a collector whose previous run does not return in
+// time, which is started once it does.
+func TestEnableLate(t *testing.T) {
+	defer func(d time.Duration) { StopTimeout = d }(StopTimeout)
+	StopTimeout = 20 * time.Millisecond
+	c := &blockingCollector{make(chan struct{})}
+	h := Run([]Collector{c})
+	defer h.StopAll()
+	if _, err := h.Disable("blocking"); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Enable("blocking"); err != nil {
+		t.Fatal(err)
+	}
+	if names := h.Running(); len(names) != 0 {
+		t.Fatalf("started before the previous run returned: %v", names)
+	}
+	close(c.release)
+	timeout := time.After(time.Second)
+	for len(h.Running()) == 0 {
+		select {
+		case <-timeout:
+			t.Fatal("not started once the previous run returned")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func TestStopProgram(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires sh")
+	}
+	dir, err := ioutil.TempDir("", "scollector")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "prog")
+	script := "#!/bin/sh\nwhile true; do echo \"test.prog $(date +%s) 1\"; sleep 0.01; done\n"
+	if err := ioutil.WriteFile(path, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	h := Run([]Collector{&ProgramCollector{Path: path}})
+	if got := receive(h, 500*time.Millisecond); got["test.prog"] == 0 {
+		t.Fatal("no data from program")
+	}
+	done := h.Stop(path)
+	for {
+		select {
+		case <-h.C:
+			continue
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("program was not stopped")
+		}
+		break
+	}
+}
+
+func TestProgramStats(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires sh")
+	}
+	dir, err := ioutil.TempDir("", "scollector")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "prog")
+	script := "#!/bin/sh\necho \"test.prog $(date +%s) 1\"\necho \"test.prog $(date +%s) 2\"\necho bad\necho \"test.prog! $(date +%s) 3\"\n"
+	if err := ioutil.WriteFile(path, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	c := &ProgramCollector{Path: path, Interval: time.Hour}
+	h := Run([]Collector{c})
+	defer h.StopAll()
+	// A bad metric name is logged but still sent.
+	if got := receive(h, 500*time.Millisecond); got["test.prog"] != 2 || got["test.prog!"] != 1 {
+		t.Fatalf("expected 3 data points, got %v", got)
+	}
+	c.stats.Lock()
+	defer c.stats.Unlock()
+	if c.stats.datapoints != 3 || c.stats.lastErr == nil || !c.stats.lastSuccess.IsZero() {
+		t.Errorf("expected 3 data points and an error, got %d, %v", c.stats.datapoints, c.stats.lastErr)
+	}
+}
+
+func TestContinuousProgramStats(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires sh")
+	}
+	defer func(f time.Duration) { DefaultFreq = f }(DefaultFreq)
+	DefaultFreq = 20 * time.Millisecond
+	dir, err := ioutil.TempDir("", "scollector")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "prog")
+	script := "#!/bin/sh\nwhile true; do echo \"test.prog $(date +%s) 1\"; sleep 0.01; done\n"
+	if err := ioutil.WriteFile(path, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	c := &ProgramCollector{Path: path}
+	h := Run([]Collector{c})
+	receive(h, 200*time.Millisecond)
+	c.stats.Lock()
+	recorded := !c.stats.lastSuccess.IsZero() && c.stats.reporting
+	c.stats.Unlock()
+	if !recorded {
+		t.Error("running program was not recorded")
+	}
+	done := h.Stop(path)
+	for {
+		select {
+		case <-h.C:
+			continue
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("program was not stopped")
+		}
+		break
+	}
+	c.stats.Lock()
+	defer c.stats.Unlock()
+	if c.stats.reporting {
+		t.Error("stopped program still reported")
+	}
+}
+
+func TestHandleControl(t *testing.T) {
+	h := Run([]Collector{testCollector("a", time.Hour)})
+	defer h.StopAll()
+	if got := receive(h, 50*time.Millisecond); got["test.a"] != 1 {
+		t.Fatalf("expected one run, got %v", got)
+	}
+	if err := h.RunNow("a"); err != nil {
+		t.Fatal(err)
+	}
+	if got := receive(h, 50*time.Millisecond); got["test.a"] != 1 {
+		t.Fatalf("expected a run on demand, got %v", got)
+	}
+	if st := h.Status(); len(st) != 1 || st[0].LastRun.IsZero() || st[0].DataPoints != 1 {
+		t.Errorf("unexpected status: %+v", st)
+	}
+
+	done, err := h.Disable("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-done
+	if err := h.RunNow("a"); err == nil {
+		t.Error("disabled collector was run")
+	}
+	// A reload does not enable it.
+	h.Update([]Collector{testCollector("a", time.Hour)})
+	if st := h.Status(); len(st) != 1 || !st[0].Disabled {
+		t.Errorf("expected a disabled, got %+v", st)
+	}
+	if err := h.Enable("a"); err != nil {
+		t.Fatal(err)
+	}
+	if got := receive(h, 50*time.Millisecond); got["test.a"] != 1 {
+		t.Fatalf("expected a to run once enabled, got %v", got)
+	}
+	if err := h.Enable("a"); err == nil {
+		t.Error("expected error enabling a running collector")
+	}
+}
+
+func TestInitOnce(t *testing.T) {
+	var inits int32
+	c := testCollector("a", time.Hour)
+	c.init = func() { atomic.AddInt32(&inits, 1) }
+	h := Run([]Collector{c})
+	defer h.StopAll()
+	receive(h, 50*time.Millisecond)
+	done, err := h.Disable("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-done
+	if err := h.Enable("a"); err != nil {
+		t.Fatal(err)
+	}
+	if got := receive(h, 50*time.Millisecond); got["test.a"] != 1 {
+		t.Fatalf("expected a to run once enabled, got %v", got)
+	}
+	if n := atomic.LoadInt32(&inits); n != 1 {
+		t.Errorf("initialized %d times, expected once", n)
+	}
+	// A new instance of the same name is initialized too.
+	c = testCollector("a", time.Minute)
+	c.init = func() { atomic.AddInt32(&inits, 1) }
+	updateReceiving(h, []Collector{c})
+	receive(h, 50*time.Millisecond)
+	if n := atomic.LoadInt32(&inits); n != 2 {
+		t.Errorf("initialized %d times, expected twice", n)
+	}
+}
+
+func TestOnce(t *testing.T) {
+	if md, err := Once(testCollector("a", time.Hour)); err != nil || len(md) != 1 {
+		t.Errorf("expected one data point, got %v, %v", md, err)
+	}
+	c := testCollector("b", time.Hour)
+	c.Enable = func() bool { return false }
+	if _, err := Once(c); err != ErrNotEnabled {
+		t.Errorf("expected ErrNotEnabled, got %v", err)
+	}
+	if _, err := Once(&ProgramCollector{Path: "prog"}); err != ErrContinuous {
+		t.Errorf("expected ErrContinuous, got %v", err)
+	}
+}
+
+// TestUpdateWaitsForStop checks that a restarted collector has returned, and
+// so released its resources such as a StatsD socket, before it is started
+// again.
+func TestUpdateWaitsForStop(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := pc.LocalAddr().String()
+	pc.Close()
+	h := Run([]Collector{&StatsDListener{Addr: addr, Freq: time.Hour}})
+	defer h.StopAll()
+	go func() {
+		for range h.C {
+		}
+	}()
+	for i := 0; i < 5; i++ {
+		h.Lock()
+		done := h.running["statsd-"+addr].done
+		h.Unlock()
+		h.Update([]Collector{&StatsDListener{Addr: addr, Freq: time.Hour + time.Duration(i+1)*time.Second}})
+		select {
+		case <-done:
+		default:
+			t.Fatal("collector restarted before the previous one returned")
+		}
+	}
+}
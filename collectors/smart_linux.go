@@ -0,0 +1,184 @@
+package collectors
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/bosun-monitor/scollector/metadata"
+	"github.com/bosun-monitor/scollector/opentsdb"
+	"github.com/bosun-monitor/scollector/util"
+)
+
+func init() {
+	collectors = append(collectors, &IntervalCollector{F: c_smart_linux, Interval: time.Minute * 5, Timeout: smartTimeout})
+}
+
+// smartDiskRE matches the names of the whole disks that may support SMART.
+// Partitions and others, such as loop, dm, md and virtio devices, do not.
+var smartDiskRE = regexp.MustCompile(`^(sd[a-z]+|hd[a-z]+|nvme\d+n\d+)$`)
+
+// smartDisks returns the names of the disks in /proc/diskstats that may
+// support SMART.
+func smartDisks() ([]string, error) {
+	var disks []string
+	err := readLine(procPath("diskstats"), func(s string) error {
+		f := strings.Fields(s)
+		if len(f) < 3 {
+			return nil
+		}
+		if smartDiskRE.MatchString(f[2]) {
+			disks = append(disks, f[2])
+		}
+		return nil
+	})
+	return disks, err
+}
+
+// smartctl is the part of the JSON output of smartctl -i -A -H --json
+// (smartctl 7.0 and later) that is reported.
+type smartctl struct {
+	Smartctl struct {
+		ExitStatus int `json:"exit_status"`
+	} `json:"smartctl"`
+	ModelName    string `json:"model_name"`
+	SerialNumber string `json:"serial_number"`
+	SmartStatus  *struct {
+		Passed bool `json:"passed"`
+	} `json:"smart_status"`
+	Temperature *struct {
+		Current int `json:"current"`
+	} `json:"temperature"`
+	PowerOnTime *struct {
+		Hours int `json:"hours"`
+	} `json:"power_on_time"`
+	ATASmartAttributes struct {
+		Table []struct {
+			ID  int `json:"id"`
+			Raw struct {
+				Value int64 `json:"value"`
+			} `json:"raw"`
+		} `json:"table"`
+	} `json:"ata_smart_attributes"`
+	NVMeSmartHealthInformationLog *struct {
+		PercentageUsed int   `json:"percentage_used"`
+		MediaErrors    int64 `json:"media_errors"`
+	} `json:"nvme_smart_health_information_log"`
+}
+
+// smartctl exit status bits meaning the device could not be read.
+const smartctlFailed = 1<<0 | 1<<1
+
+// smartctlTimeout is how long smartctl may take for each disk.
+const smartctlTimeout = time.Second * 30
+
+// smartTimeout is how long reading all disks may take.
+var smartTimeout = time.Minute * 4
+
+// ATA SMART attribute IDs.
+const (
+	smartReallocatedSectors = 5
+	smartPendingSectors     = 197
+)
+
+const (
+	descSmartHealth      = "0 if the disk passed its SMART overall health self-assessment, 1 if it failed and is likely to fail soon."
+	descSmartReallocated = "Number of sectors of an ATA disk remapped to spare sectors after read or write errors."
+	descSmartPending     = "Number of unstable sectors of an ATA disk waiting to be remapped."
+	descSmartTemperature = "Current temperature of the disk."
+	descSmartPowerOn     = "Number of hours the disk has been powered on."
+	descSmartWear        = "Estimated percentage of the NVMe disk's life used, which may exceed 100."
+	descSmartMediaErrors = "Number of unrecovered data integrity errors of the NVMe disk."
+)
+
+// c_smart_linux reports the SMART health of the disks from smartctl, if
+// installed. Disks in standby are not woken up and report nothing. Disks
+// smartctl cannot read, such as those behind some RAID controllers and USB
+// bridges, are skipped, but it is an error if none can be read, as when not
+// run as root. If reading the disks takes too long, those read are reported
+// and the others skipped.
+func c_smart_linux() (opentsdb.MultiDataPoint, error) {
+	disks, err := smartDisks()
+	if err != nil {
+		return nil, err
+	}
+	var md opentsdb.MultiDataPoint
+	var errs []string
+	read := 0
+	// runCommand may take twice its timeout, so smartctl is only given the
+	// time left until half of smartTimeout for all runs to end within it.
+	deadline := time.Now().Add(smartTimeout / 2)
+	for i, dev := range disks {
+		timeout := deadline.Sub(time.Now())
+		if timeout <= 0 {
+			errs = append(errs, fmt.Sprintf("timed out before reading %s", strings.Join(disks[i:], ", ")))
+			break
+		}
+		if timeout > smartctlTimeout {
+			timeout = smartctlTimeout
+		}
+		// smartctl exits with a non-zero status for failing disks too, so
+		// the output is parsed whatever the error.
+		b, err := runCommand(timeout, "smartctl", "-n", "standby,0", "-i", "-A", "-H", "--json", "/dev/"+dev)
+		if err == util.ErrPath {
+			return nil, nil
+		} else if err == util.ErrTimeout {
+			errs = append(errs, fmt.Sprintf("%s: %v", dev, err))
+			continue
+		}
+		var s smartctl
+		if err := json.Unmarshal(b, &s); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", dev, err))
+			continue
+		}
+		if s.Smartctl.ExitStatus&smartctlFailed != 0 {
+			continue
+		}
+		read++
+		addSmart(&md, dev, &s)
+	}
+	if len(errs) > 0 {
+		return md, fmt.Errorf("smartctl: %s", strings.Join(errs, "; "))
+	}
+	if read == 0 && len(disks) > 0 {
+		return nil, fmt.Errorf("smartctl: could not read any of %s", strings.Join(disks, ", "))
+	}
+	return md, nil
+}
+
+func addSmart(md *opentsdb.MultiDataPoint, dev string, s *smartctl) {
+	ts := opentsdb.TagSet{"dev": dev}
+	if s.ModelName != "" {
+		metadata.AddMeta("", ts, "model", s.ModelName, true)
+	}
+	if s.SerialNumber != "" {
+		metadata.AddMeta("", ts, "serial", s.SerialNumber, true)
+	}
+	if s.SmartStatus != nil {
+		health := 0
+		if !s.SmartStatus.Passed {
+			health = 1
+		}
+		Add(md, "linux.disk.smart.health", health, ts, metadata.Gauge, metadata.Ok, descSmartHealth)
+	}
+	for _, a := range s.ATASmartAttributes.Table {
+		switch a.ID {
+		case smartReallocatedSectors:
+			Add(md, "linux.disk.smart.reallocated_sectors", a.Raw.Value, ts, metadata.Gauge, metadata.Count, descSmartReallocated)
+		case smartPendingSectors:
+			Add(md, "linux.disk.smart.pending_sectors", a.Raw.Value, ts, metadata.Gauge, metadata.Count, descSmartPending)
+		}
+	}
+	if s.Temperature != nil {
+		Add(md, "linux.disk.smart.temperature", s.Temperature.Current, ts, metadata.Gauge, metadata.C, descSmartTemperature)
+	}
+	if s.PowerOnTime != nil {
+		Add(md, "linux.disk.smart.power_on_hours", s.PowerOnTime.Hours, ts, metadata.Counter, metadata.Hour, descSmartPowerOn)
+	}
+	if l := s.NVMeSmartHealthInformationLog; l != nil {
+		Add(md, "linux.disk.smart.wear", l.PercentageUsed, ts, metadata.Gauge, metadata.Pct, descSmartWear)
+		Add(md, "linux.disk.smart.media_errors", l.MediaErrors, ts, metadata.Counter, metadata.Count, descSmartMediaErrors)
+	}
+}
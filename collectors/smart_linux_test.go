@@ -0,0 +1,52 @@
+package collectors
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bosun-monitor/scollector/util"
+)
+
+func TestSmartNoAccess(t *testing.T) {
+	defer func(proc string, run func(time.Duration, string, ...string) ([]byte, error)) {
+		ProcRoot, runCommand = proc, run
+	}(ProcRoot, runCommand)
+	ProcRoot = filepath.Join("testdata", "smart_linux", "proc")
+	// As run by a user other than root.
+	runCommand = func(timeout time.Duration, name string, arg ...string) ([]byte, error) {
+		return []byte(`{"smartctl": {"exit_status": 2}}`), nil
+	}
+	if _, err := c_smart_linux(); err == nil {
+		t.Error("expected an error when no disk can be read")
+	}
+}
+
+func TestSmartTimeout(t *testing.T) {
+	defer func(proc string, run func(time.Duration, string, ...string) ([]byte, error), timeout time.Duration) {
+		ProcRoot, runCommand, smartTimeout = proc, run, timeout
+	}(ProcRoot, runCommand, smartTimeout)
+	ProcRoot = filepath.Join("testdata", "smart_linux", "proc")
+	smartTimeout = 100 * time.Millisecond
+	// sda is read, then sdb hangs until killed.
+	runCommand = func(timeout time.Duration, name string, arg ...string) ([]byte, error) {
+		if arg[len(arg)-1] == "/dev/sda" {
+			return []byte(`{"smart_status": {"passed": true}}`), nil
+		}
+		time.Sleep(2 * timeout)
+		return nil, util.ErrTimeout
+	}
+	start := time.Now()
+	md, err := c_smart_linux()
+	// Allow for the scheduling of the last run, which lasts until killed.
+	if d := time.Since(start); d > smartTimeout+50*time.Millisecond {
+		t.Errorf("took %v, longer than %v", d, smartTimeout)
+	}
+	if err == nil || !strings.Contains(err.Error(), "timed out before reading sdc") {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if len(md) != 1 || md[0].Tags["dev"] != "sda" {
+		t.Errorf("expected the health of sda, got %v", md)
+	}
+}
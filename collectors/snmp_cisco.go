@@ -17,13 +17,18 @@ const (
 )
 
 // SNMPCisco registers a SNMP CISCO collector for the given community and host.
-func SNMPCisco(community, host string) {
+// Interval defaults to 30 seconds if 0.
+func SNMPCisco(community, host string, interval time.Duration) {
+	if interval == 0 {
+		interval = time.Second * 30
+	}
 	collectors = append(collectors, &IntervalCollector{
 		F: func() (opentsdb.MultiDataPoint, error) {
 			return c_snmp_cisco(community, host)
 		},
-		Interval: time.Second * 30,
+		Interval: interval,
 		name:     fmt.Sprintf("snmp-cisco-%s", host),
+		settings: community,
 	})
 }
 
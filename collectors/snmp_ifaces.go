@@ -27,14 +27,19 @@ const (
 	ifOutErrors          = ".1.3.6.1.2.1.2.2.1.20"
 )
 
-// SNMPIfaces registers a SNMP Interfaces collector for the given community and host.
-func SNMPIfaces(community, host string) {
+// SNMPIfaces registers a SNMP Interfaces collector for the given community and
+// host. Interval defaults to 30 seconds if 0.
+func SNMPIfaces(community, host string, interval time.Duration) {
+	if interval == 0 {
+		interval = time.Second * 30
+	}
 	collectors = append(collectors, &IntervalCollector{
 		F: func() (opentsdb.MultiDataPoint, error) {
 			return c_snmp_ifaces(community, host)
 		},
-		Interval: time.Second * 30,
+		Interval: interval,
 		name:     fmt.Sprintf("snmp-ifaces-%s", host),
+		settings: community,
 	})
 }
 
@@ -7,7 +7,6 @@ import (
 
 	"github.com/bosun-monitor/scollector/metadata"
 	"github.com/bosun-monitor/scollector/opentsdb"
-	"github.com/bosun-monitor/scollector/util"
 )
 
 func init() {
@@ -24,7 +23,7 @@ func c_sntp_windows() (opentsdb.MultiDataPoint, error) {
 		source  string
 		poll    float64
 	)
-	if err := util.ReadCommand(func(line string) error {
+	if err := readCommand(func(line string) error {
 		f := strings.SplitN(line, ":", 2)
 		if len(f) != 2 {
 			return nil
@@ -75,7 +74,7 @@ func c_sntp_windows() (opentsdb.MultiDataPoint, error) {
 	Add(&md, metric+"delay", delay, tags, metadata.Gauge, metadata.Second, "")
 	Add(&md, metric+"when", when, tags, metadata.Gauge, metadata.Second, "")
 	Add(&md, metric+"poll", poll, tags, metadata.Gauge, metadata.Second, "")
-	err := util.ReadCommand(func(line string) error {
+	err := readCommand(func(line string) error {
 		f := strings.SplitN(line, ",", 2)
 		if len(f) != 2 {
 			return nil
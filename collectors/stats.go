@@ -0,0 +1,94 @@
+package collectors
+
+import (
+	"sync"
+	"time"
+
+	"github.com/bosun-monitor/scollector/collect"
+	"github.com/bosun-monitor/scollector/opentsdb"
+)
+
+// runStats records the outcome of the runs of a collector and reports it in
+// the self metrics collector.duration (seconds), collector.datapoints,
+// collector.error (1 if the last run failed) and collector.last_success (Unix
+// time), tagged with the collector name.
+type runStats struct {
+	sync.Mutex
+	lastRun     time.Time
+	lastSuccess time.Time
+	lastErr     error
+	duration    time.Duration
+	datapoints  int
+	reporting   bool
+}
+
+// record records a run of the named collector that started at start,
+// produced n data points and returned err.
+func (s *runStats) record(name string, start time.Time, n int, err error) {
+	s.Lock()
+	defer s.Unlock()
+	s.lastRun = start
+	s.duration = time.Since(start)
+	s.datapoints = n
+	s.lastErr = err
+	if err == nil {
+		s.lastSuccess = start
+	}
+	if !s.reporting && !collect.DisableDefaultCollectors {
+		s.reporting = true
+		s.report(name)
+	}
+}
+
+// statsMetrics are the self metrics reported by runStats.
+var statsMetrics = []string{
+	"collector.duration",
+	"collector.datapoints",
+	"collector.error",
+	"collector.last_success",
+}
+
+// stop stops reporting the self metrics of the named collector, once it has
+// returned.
+func (s *runStats) stop(name string) {
+	s.Lock()
+	defer s.Unlock()
+	if !s.reporting {
+		return
+	}
+	s.reporting = false
+	ts := opentsdb.TagSet{"collector": statName(name)}
+	for _, m := range statsMetrics {
+		collect.Unset(m, ts)
+	}
+}
+
+func (s *runStats) report(name string) {
+	ts := opentsdb.TagSet{"collector": statName(name)}
+	collect.Set("collector.duration", ts, func() interface{} {
+		s.Lock()
+		defer s.Unlock()
+		return s.duration.Seconds()
+	})
+	collect.Set("collector.datapoints", ts, func() interface{} {
+		s.Lock()
+		defer s.Unlock()
+		return s.datapoints
+	})
+	collect.Set("collector.error", ts, func() interface{} {
+		s.Lock()
+		defer s.Unlock()
+		if s.lastErr != nil {
+			return 1
+		}
+		return 0
+	})
+	collect.Set("collector.last_success", ts, func() interface{} {
+		s.Lock()
+		defer s.Unlock()
+		if s.lastSuccess.IsZero() {
+			return 0
+		}
+		return s.lastSuccess.Unix()
+	})
+}
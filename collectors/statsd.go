@@ -0,0 +1,283 @@
+package collectors
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/StackExchange/slog"
+	"github.com/bosun-monitor/scollector/collect"
+	"github.com/bosun-monitor/scollector/metadata"
+	"github.com/bosun-monitor/scollector/opentsdb"
+)
+
+// StatsDListener is a collector that accepts StatsD packets over UDP and
+// aggregates them locally, sending the results every Freq. DogStatsD style
+// tags ("|#k:v,k2:v2") are supported.
+type StatsDListener struct {
+	Addr string
+	Freq time.Duration
+
+	sync.Mutex // protects the maps below
+	counters   map[string]*statsdSeries
+	gauges     map[string]*statsdSeries
+	timers     map[string]*statsdSeries
+	sets       map[string]*statsdSeries
+}
+
+// statsdSeries is the aggregated state of one metric and tag set.
+type statsdSeries struct {
+	metric string
+	tags   opentsdb.TagSet
+	count  float64
+	value  float64
+	set    map[string]bool
+
+	// Timer values received, their exact min, max and sum, and a reservoir of
+	// at most statsdMaxTimerValues of them for percentiles.
+	n             int64
+	min, max, sum float64
+	values        []float64
+}
+
+// statsdMaxTimerValues bounds the timer values kept per series and interval.
+const statsdMaxTimerValues = 10000
+
+// ListenStatsD registers a StatsDListener on addr (ex: ":8125") that sends
+// aggregated values every freq.
+func ListenStatsD(addr string, freq time.Duration) {
+	collectors = append(collectors, &StatsDListener{Addr: addr, Freq: freq})
+}
+
+func (s *StatsDListener) Init() {
+	s.counters = make(map[string]*statsdSeries)
+	s.gauges = make(map[string]*statsdSeries)
+	s.timers = make(map[string]*statsdSeries)
+	s.sets = make(map[string]*statsdSeries)
+}
+
+func (s *StatsDListener) Name() string {
+	return "statsd-" + s.Addr
+}
+
+func (s *StatsDListener) Run(ctx context.Context, dpchan chan<- *opentsdb.DataPoint) {
+	if s.Freq <= 0 {
+		s.Freq = DefaultFreq
+	}
+	pc, err := net.ListenPacket("udp", s.Addr)
+	if err != nil {
+		slog.Errorf("%v: %v", s.Name(), err)
+		return
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	flushed := make(chan struct{})
+	go func() {
+		defer close(flushed)
+		t := time.NewTicker(s.Freq)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				for _, dp := range s.flush() {
+					dpchan <- dp
+				}
+			case <-ctx.Done():
+				pc.Close()
+				return
+			}
+		}
+	}()
+	defer func() {
+		cancel()
+		<-flushed
+		// Send what was received since the last flush.
+		for _, dp := range s.flush() {
+			dpchan <- dp
+		}
+	}()
+	buf := make([]byte, 65536)
+	for {
+		n, _, err := pc.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() == nil {
+				slog.Errorf("%v: %v", s.Name(), err)
+			}
+			return
+		}
+		for _, line := range strings.Split(string(buf[:n]), "\n") {
+			if line = strings.TrimSpace(line); line == "" {
+				continue
+			}
+			if err := s.handle(line); err != nil {
+				slog.Errorf("%v: %v", s.Name(), err)
+			}
+		}
+	}
+}
+
+// handle parses a single StatsD line of the form
+// "metric:value|type[|@rate][|#k:v,...]" and records it.
+func (s *StatsDListener) handle(line string) error {
+	// Tags may contain colons, so find the value separator before the first
+	// pipe.
+	pipe := strings.Index(line, "|")
+	if pipe < 0 {
+		return fmt.Errorf("bad line: %q", line)
+	}
+	colon := strings.LastIndex(line[:pipe], ":")
+	if colon < 0 {
+		return fmt.Errorf("bad line: %q", line)
+	}
+	metric, err := opentsdb.Clean(line[:colon])
+	if err != nil {
+		return fmt.Errorf("bad metric in %q: %v", line, err)
+	}
+	fields := strings.Split(line[colon+1:], "|")
+	if len(fields) < 2 {
+		return fmt.Errorf("bad line: %q", line)
+	}
+	raw, typ := fields[0], fields[1]
+	rate := 1.0
+	tags := make(opentsdb.TagSet)
+	for _, f := range fields[2:] {
+		switch {
+		case strings.HasPrefix(f, "@"):
+			r, err := strconv.ParseFloat(f[1:], 64)
+			if err != nil || r <= 0 || r > 1 {
+				return fmt.Errorf("bad sample rate in %q", line)
+			}
+			rate = r
+		case strings.HasPrefix(f, "#"):
+			if err := parseStatsDTags(f[1:], tags); err != nil {
+				return fmt.Errorf("%v in %q", err, line)
+			}
+		}
+	}
+	key := metric + tags.String()
+	s.Lock()
+	defer s.Unlock()
+	switch typ {
+	case "c":
+		v, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return fmt.Errorf("bad value in %q", line)
+		}
+		statsdGet(s.counters, key, metric, tags).count += v / rate
+	case "g":
+		v, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return fmt.Errorf("bad value in %q", line)
+		}
+		g := statsdGet(s.gauges, key, metric, tags)
+		if strings.HasPrefix(raw, "+") || strings.HasPrefix(raw, "-") {
+			g.value += v
+		} else {
+			g.value = v
+		}
+	case "ms", "h":
+		v, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return fmt.Errorf("bad value in %q", line)
+		}
+		t := statsdGet(s.timers, key, metric, tags)
+		t.count += 1 / rate
+		t.n++
+		if t.n == 1 || v < t.min {
+			t.min = v
+		}
+		if t.n == 1 || v > t.max {
+			t.max = v
+		}
+		t.sum += v
+		if len(t.values) < statsdMaxTimerValues {
+			t.values = append(t.values, v)
+		} else if i := rand.Int63n(t.n); i < int64(len(t.values)) {
+			// Reservoir sampling: each value is kept with equal probability.
+			t.values[i] = v
+		}
+	case "s":
+		st := statsdGet(s.sets, key, metric, tags)
+		if st.set == nil {
+			st.set = make(map[string]bool)
+		}
+		st.set[raw] = true
+	default:
+		return fmt.Errorf("unknown type %q in %q", typ, line)
+	}
+	return nil
+}
+
+func statsdGet(m map[string]*statsdSeries, key, metric string, tags opentsdb.TagSet) *statsdSeries {
+	if s := m[key]; s != nil {
+		return s
+	}
+	s := &statsdSeries{metric: metric, tags: tags}
+	m[key] = s
+	return s
+}
+
+// parseStatsDTags parses DogStatsD tags of the form "k:v,k2:v2" into ts. Tags
+// without a value are ignored.
+func parseStatsDTags(s string, ts opentsdb.TagSet) error {
+	for _, t := range strings.Split(s, ",") {
+		kv := strings.SplitN(t, ":", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		if kv[0] == "host" && kv[1] == "" {
+			ts["host"] = ""
+			continue
+		}
+		k, err := opentsdb.Clean(kv[0])
+		if err != nil {
+			return fmt.Errorf("bad tag key %q", kv[0])
+		}
+		v, err := opentsdb.Clean(kv[1])
+		if err != nil {
+			return fmt.Errorf("bad tag value %q", kv[1])
+		}
+		ts[k] = v
+	}
+	return nil
+}
+
+// flush returns the aggregated data points since the last flush and resets
+// counters, timers and sets. Gauges keep their value and are sent every flush,
+// as with StatsD.
+func (s *StatsDListener) flush() opentsdb.MultiDataPoint {
+	var md opentsdb.MultiDataPoint
+	secs := s.Freq.Seconds()
+	s.Lock()
+	defer s.Unlock()
+	for _, c := range s.counters {
+		Add(&md, c.metric+".count", c.count, c.tags, metadata.Gauge, metadata.Count, "")
+		Add(&md, c.metric+".rate", c.count/secs, c.tags, metadata.Rate, metadata.PerSecond, "")
+	}
+	for _, g := range s.gauges {
+		Add(&md, g.metric, g.value, g.tags, metadata.Gauge, metadata.None, "")
+	}
+	for _, t := range s.timers {
+		Add(&md, t.metric+".count", t.count, t.tags, metadata.Gauge, metadata.Count, "")
+		Add(&md, t.metric+".rate", t.count/secs, t.tags, metadata.Rate, metadata.PerSecond, "")
+		sort.Float64s(t.values)
+		Add(&md, t.metric+".min", t.min, t.tags, metadata.Gauge, metadata.MilliSecond, "")
+		Add(&md, t.metric+".max", t.max, t.tags, metadata.Gauge, metadata.MilliSecond, "")
+		Add(&md, t.metric+".mean", t.sum/float64(t.n), t.tags, metadata.Gauge, metadata.MilliSecond, "")
+		Add(&md, t.metric+".median", collect.Percentile(t.values, .5), t.tags, metadata.Gauge, metadata.MilliSecond, "")
+		Add(&md, t.metric+".p95", collect.Percentile(t.values, .95), t.tags, metadata.Gauge, metadata.MilliSecond, "")
+		Add(&md, t.metric+".p99", collect.Percentile(t.values, .99), t.tags, metadata.Gauge, metadata.MilliSecond, "")
+	}
+	for _, st := range s.sets {
+		Add(&md, st.metric+".count", len(st.set), st.tags, metadata.Gauge, metadata.Count, "")
+	}
+	s.counters = make(map[string]*statsdSeries)
+	s.timers = make(map[string]*statsdSeries)
+	s.sets = make(map[string]*statsdSeries)
+	return md
+}
@@ -3,6 +3,7 @@ package collectors
 import (
 	"fmt"
 	"strconv"
+	"time"
 
 	"github.com/StackExchange/vsphere"
 	"github.com/bosun-monitor/scollector/metadata"
@@ -10,13 +11,15 @@ import (
 	"github.com/bosun-monitor/scollector/util"
 )
 
-// Vsphere registers a vSphere collector.
-func Vsphere(user, pwd, host string) {
+// Vsphere registers a vSphere collector. Interval defaults to DefaultFreq if 0.
+func Vsphere(user, pwd, host string, interval time.Duration) {
 	collectors = append(collectors, &IntervalCollector{
 		F: func() (opentsdb.MultiDataPoint, error) {
 			return c_vsphere(user, pwd, host)
 		},
-		name: fmt.Sprintf("vsphere-%s", host),
+		Interval: interval,
+		name:     fmt.Sprintf("vsphere-%s", host),
+		settings: user + ":" + pwd,
 	})
 }
 
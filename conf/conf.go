@@ -0,0 +1,196 @@
+// Package conf parses the scollector configuration file.
+//
+// The file holds key = value pairs. Top level keys correspond to command line
+// flags. Sections configure collectors and outputs, and may be repeated:
+//
+//	filter = "snmp"
+//	spool = "/var/spool/scollector"
+//
+//	[[output]]
+//	url = "http://bosun:8070"
+//
+//	[[snmp]]
+//	community = "public"
+//	host = "switch01"
+//	interval = "1m"
+//
+// Errors include the line number of the offending key or section.
+package conf
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/bosun-monitor/scollector/opentsdb"
+)
+
+// Conf is the content of a configuration file.
+type Conf struct {
+	Filter          string        `conf:"filter"`
+	ColDir          string        `conf:"coldir"`
+	BatchSize       int           `conf:"batch_size"`
+	FullHost        bool          `conf:"full_host"`
+	DisableMetadata bool          `conf:"disable_metadata"`
+	DisableSelf     bool          `conf:"disable_self"`
+	Spool           string        `conf:"spool"`
+	Put             string        `conf:"put"`
+	Relay           string        `conf:"relay"`
+	StatsD          string        `conf:"statsd"`
+	Prom            string        `conf:"prom"`
+	Status          string        `conf:"status"`
+	Procfs          string        `conf:"procfs"`
+	Sysfs           string        `conf:"sysfs"`
+	DockerLabels    string        `conf:"docker_labels"`
+	CgroupDepth     int           `conf:"cgroup_depth"`
+	Drain           time.Duration `conf:"drain"`
+	Timeout         time.Duration `conf:"timeout"`
+
+	Output  []Output  `conf:"output"`
+	SNMP    []SNMP    `conf:"snmp"`
+	ICMP    []ICMP    `conf:"icmp"`
+	Vsphere []Vsphere `conf:"vsphere"`
+	Process []Process `conf:"process"`
+	Program []Program `conf:"program"`
+}
+
+// Output is a destination data is sent to. URL is of the same form as the -h
+// flag. BatchSize and MaxQueueLen default to the global settings.
+type Output struct {
+	URL         string `conf:"url"`
+	BatchSize   int    `conf:"batch_size"`
+	MaxQueueLen int    `conf:"max_queue_len"`
+}
+
+func (o *Output) validate() error {
+	if o.URL == "" {
+		return fmt.Errorf("missing url")
+	}
+	if _, err := url.Parse(o.URL); err != nil {
+		return err
+	}
+	if o.BatchSize < 0 || o.MaxQueueLen < 0 {
+		return fmt.Errorf("batch_size and max_queue_len may not be negative")
+	}
+	return nil
+}
+
+// SNMP polls the interfaces and Cisco MIBs of Host. Interval defaults to 30
+// seconds.
+type SNMP struct {
+	Community string        `conf:"community"`
+	Host      string        `conf:"host"`
+	Interval  time.Duration `conf:"interval"`
+}
+
+func (s *SNMP) validate() error {
+	if s.Community == "" {
+		return fmt.Errorf("missing community")
+	}
+	return validHost(s.Host, s.Interval)
+}
+
+// ICMP pings Host.
+type ICMP struct {
+	Host     string        `conf:"host"`
+	Interval time.Duration `conf:"interval"`
+}
+
+func (i *ICMP) validate() error {
+	return validHost(i.Host, i.Interval)
+}
+
+// Vsphere polls the vSphere server at Host.
+type Vsphere struct {
+	User     string        `conf:"user"`
+	Password string        `conf:"password"`
+	Host     string        `conf:"host"`
+	Interval time.Duration `conf:"interval"`
+}
+
+func (v *Vsphere) validate() error {
+	if v.User == "" || v.Password == "" {
+		return fmt.Errorf("missing user or password")
+	}
+	return validHost(v.Host, v.Interval)
+}
+
+// Process watches processes named Command whose arguments match the regular
+// expression Args. Name is the name tag and defaults to Command.
+type Process struct {
+	Command string `conf:"command"`
+	Name    string `conf:"name"`
+	Args    string `conf:"args"`
+}
+
+func (p *Process) validate() error {
+	if p.Command == "" {
+		return fmt.Errorf("missing command")
+	}
+	if strings.Contains(p.Command, ",") || strings.Contains(p.Name, ",") {
+		return fmt.Errorf("command and name may not contain a comma")
+	}
+	if p.Name != "" && !opentsdb.ValidTag(p.Name) {
+		return fmt.Errorf("bad name: %v", p.Name)
+	}
+	_, err := regexp.Compile(p.Args)
+	return err
+}
+
+// Program is an external collector. Interval 0 means the program is expected
+// to run continuously and is restarted if it exits.
+type Program struct {
+	Path     string        `conf:"path"`
+	Interval time.Duration `conf:"interval"`
+}
+
+func (p *Program) validate() error {
+	if p.Path == "" {
+		return fmt.Errorf("missing path")
+	}
+	if p.Interval < 0 {
+		return fmt.Errorf("interval may not be negative")
+	}
+	return nil
+}
+
+func validHost(host string, interval time.Duration) error {
+	if host == "" {
+		return fmt.Errorf("missing host")
+	}
+	if interval < 0 {
+		return fmt.Errorf("interval may not be negative")
+	}
+	return nil
+}
+
+// Parse parses the configuration in text.
+func Parse(text string) (*Conf, error) {
+	t, err := parse(text)
+	if err != nil {
+		return nil, err
+	}
+	c := new(Conf)
+	if err := decode("", t, reflect.ValueOf(c)); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Load reads and parses the configuration file at path. Errors are prefixed
+// with path.
+func Load(path string) (*Conf, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c, err := Parse(string(b))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return c, nil
+}
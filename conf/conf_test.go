@@ -0,0 +1,86 @@
+package conf
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	c, err := Parse(`
+# Comment.
+filter = "snmp" # Trailing comment.
+batch_size = 100
+full_host = true
+
+[[output]]
+url = "http://bosun:8070"
+
+[[output]]
+url = 'graphite://carbon:2003?template=host.metric'
+max_queue_len = 1_000
+
+[[snmp]]
+community = "public"
+host = "switch01"
+interval = "1m"
+
+[[snmp]]
+community = "private"
+host = "switch02"
+
+[[process]]
+command = "java"
+name = "tomcat"
+args = "catalina,\\s+start"
+
+[[program]]
+path = "/opt/collectors/app"
+interval = 30
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Filter != "snmp" || c.BatchSize != 100 || !c.FullHost {
+		t.Errorf("bad top level settings: %+v", c)
+	}
+	if len(c.Output) != 2 || c.Output[1].URL != "graphite://carbon:2003?template=host.metric" || c.Output[1].MaxQueueLen != 1000 {
+		t.Errorf("bad outputs: %+v", c.Output)
+	}
+	if len(c.SNMP) != 2 || c.SNMP[0].Interval != time.Minute || c.SNMP[1].Host != "switch02" {
+		t.Errorf("bad snmp: %+v", c.SNMP)
+	}
+	if len(c.Process) != 1 || c.Process[0].Args != `catalina,\s+start` {
+		t.Errorf("bad process: %+v", c.Process)
+	}
+	if len(c.Program) != 1 || c.Program[0].Interval != 30*time.Second {
+		t.Errorf("bad program: %+v", c.Program)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		text string
+		err  string
+	}{
+		{"host = bosun", `line 1: host: invalid value "bosun" (strings must be quoted)`},
+		{"filter = \"a\"\nfilter = \"b\"", "line 2: filter already set on line 1"},
+		{"\n\nnope = 1", `line 3: unknown key "nope"`},
+		{"batch_size = \"1\"", "line 1: batch_size: expected an integer"},
+		{"[[snmp]]\nhost = \"switch01\"", "line 1: [[snmp]]: missing community"},
+		{"[[icmp]]\nhost = \"a\"\ninterval = \"soon\"", `line 3: interval: time: invalid duration`},
+		{"[snmp]\nhost = \"a\"", "line 1: snmp: expected [[snmp]] section"},
+		{"[[process]\n", "line 1: missing ]]"},
+		{"[[process]]\ncommand = \"java\"\nargs = \"(\"", "line 1: [[process]]: error parsing regexp"},
+		{"filter = \"a\" b", `line 1: filter: unexpected "b"`},
+		{"filter = [\"a\"", "line 1: filter: unterminated array"},
+	}
+	for _, test := range tests {
+		_, err := Parse(test.text)
+		if err == nil {
+			t.Errorf("%q: expected error", test.text)
+		} else if !strings.HasPrefix(err.Error(), test.err) {
+			t.Errorf("%q: got %q, expected %q", test.text, err, test.err)
+		}
+	}
+}
@@ -0,0 +1,341 @@
+package conf
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// table is a parsed [section], [[section]] or the top level of a file.
+type table struct {
+	line    int
+	entries map[string]*entry
+}
+
+// entry is a key's value and the line it was set on. The value is a string,
+// int64, float64, bool, []interface{}, *table or []*table.
+type entry struct {
+	line  int
+	value interface{}
+}
+
+func newTable(line int) *table {
+	return &table{line: line, entries: make(map[string]*entry)}
+}
+
+func lineError(line int, format string, args ...interface{}) error {
+	return fmt.Errorf("line %d: %s", line, fmt.Sprintf(format, args...))
+}
+
+// parse parses the configuration file syntax: top level [section] and
+// [[section]] headers, key = value pairs with string, integer, float, boolean
+// and single line array values, and # comments.
+func parse(text string) (*table, error) {
+	root := newTable(0)
+	cur := root
+	for i, line := range strings.Split(text, "\n") {
+		n := i + 1
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		if line[0] == '[' {
+			array := strings.HasPrefix(line, "[[")
+			end := "]"
+			if array {
+				line, end = line[2:], "]]"
+			} else {
+				line = line[1:]
+			}
+			j := strings.Index(line, end)
+			if j < 0 {
+				return nil, lineError(n, "missing %s", end)
+			}
+			if err := trailing(line[j+len(end):]); err != nil {
+				return nil, lineError(n, "%v", err)
+			}
+			name := strings.TrimSpace(line[:j])
+			if !validKey(name) {
+				return nil, lineError(n, "invalid section name %q", name)
+			}
+			cur = newTable(n)
+			e := root.entries[name]
+			switch {
+			case e == nil && array:
+				root.entries[name] = &entry{n, []*table{cur}}
+			case e == nil:
+				root.entries[name] = &entry{n, cur}
+			default:
+				tables, ok := e.value.([]*table)
+				if !ok || !array {
+					return nil, lineError(n, "%s already defined on line %d", name, e.line)
+				}
+				e.value = append(tables, cur)
+			}
+			continue
+		}
+		j := strings.Index(line, "=")
+		if j < 0 {
+			return nil, lineError(n, "expected key = value")
+		}
+		key := strings.TrimSpace(line[:j])
+		if !validKey(key) {
+			return nil, lineError(n, "invalid key %q", key)
+		}
+		if e := cur.entries[key]; e != nil {
+			return nil, lineError(n, "%s already set on line %d", key, e.line)
+		}
+		v, rest, err := parseValue(strings.TrimSpace(line[j+1:]))
+		if err != nil {
+			return nil, lineError(n, "%s: %v", key, err)
+		}
+		if err := trailing(rest); err != nil {
+			return nil, lineError(n, "%s: %v", key, err)
+		}
+		cur.entries[key] = &entry{n, v}
+	}
+	return root, nil
+}
+
+func validKey(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '_', c == '-':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// trailing returns an error if s contains anything but a comment.
+func trailing(s string) error {
+	s = strings.TrimSpace(s)
+	if s != "" && s[0] != '#' {
+		return fmt.Errorf("unexpected %q", s)
+	}
+	return nil
+}
+
+// parseValue parses the value at the start of s and returns the rest of s.
+func parseValue(s string) (v interface{}, rest string, err error) {
+	if s == "" {
+		return nil, "", fmt.Errorf("missing value")
+	}
+	switch s[0] {
+	case '"':
+		for i := 1; i < len(s); i++ {
+			switch s[i] {
+			case '\\':
+				i++
+			case '"':
+				v, err := strconv.Unquote(s[:i+1])
+				if err != nil {
+					return nil, "", fmt.Errorf("invalid string %s", s[:i+1])
+				}
+				return v, s[i+1:], nil
+			}
+		}
+		return nil, "", fmt.Errorf("unterminated string")
+	case '\'':
+		i := strings.Index(s[1:], "'")
+		if i < 0 {
+			return nil, "", fmt.Errorf("unterminated string")
+		}
+		return s[1 : i+1], s[i+2:], nil
+	case '[':
+		var a []interface{}
+		s = strings.TrimSpace(s[1:])
+		for {
+			if s == "" {
+				return nil, "", fmt.Errorf("unterminated array")
+			}
+			if s[0] == ']' {
+				return a, s[1:], nil
+			}
+			v, rest, err := parseValue(s)
+			if err != nil {
+				return nil, "", err
+			}
+			a = append(a, v)
+			s = strings.TrimSpace(rest)
+			if s == "" {
+				return nil, "", fmt.Errorf("unterminated array")
+			} else if s[0] == ',' {
+				s = strings.TrimSpace(s[1:])
+			} else if s[0] != ']' {
+				return nil, "", fmt.Errorf("expected , or ] in array")
+			}
+		}
+	}
+	i := strings.IndexAny(s, " \t,]#")
+	if i < 0 {
+		i = len(s)
+	}
+	tok, rest := s[:i], s[i:]
+	switch tok {
+	case "true":
+		return true, rest, nil
+	case "false":
+		return false, rest, nil
+	}
+	num := strings.Replace(tok, "_", "", -1)
+	if n, err := strconv.ParseInt(num, 10, 64); err == nil {
+		return n, rest, nil
+	}
+	if f, err := strconv.ParseFloat(num, 64); err == nil {
+		return f, rest, nil
+	}
+	return nil, "", fmt.Errorf("invalid value %q (strings must be quoted)", tok)
+}
+
+// A validator checks a decoded section.
+type validator interface {
+	validate() error
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// decode stores the entries of t in the struct pointed to by v. Struct fields
+// are matched by their conf tag. Sections that implement validator are
+// validated once decoded.
+func decode(name string, t *table, v reflect.Value) error {
+	st := v.Elem().Type()
+	fields := make(map[string]int)
+	for i := 0; i < st.NumField(); i++ {
+		if tag := st.Field(i).Tag.Get("conf"); tag != "" {
+			fields[tag] = i
+		}
+	}
+	keys := make([]string, 0, len(t.entries))
+	for k := range t.entries {
+		keys = append(keys, k)
+	}
+	sort.Sort(byLine{keys, t})
+	for _, k := range keys {
+		e := t.entries[k]
+		i, ok := fields[k]
+		if !ok {
+			return lineError(e.line, "unknown key %q", k)
+		}
+		if err := decodeValue(k, e, v.Elem().Field(i)); err != nil {
+			return err
+		}
+	}
+	if val, ok := v.Interface().(validator); ok {
+		if err := val.validate(); err != nil {
+			if name == "" {
+				return lineError(t.line, "%v", err)
+			}
+			return lineError(t.line, "%s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+type byLine struct {
+	keys []string
+	t    *table
+}
+
+func (b byLine) Len() int      { return len(b.keys) }
+func (b byLine) Swap(i, j int) { b.keys[i], b.keys[j] = b.keys[j], b.keys[i] }
+func (b byLine) Less(i, j int) bool {
+	return b.t.entries[b.keys[i]].line < b.t.entries[b.keys[j]].line
+}
+
+func decodeValue(key string, e *entry, f reflect.Value) error {
+	bad := func(want string) error {
+		return lineError(e.line, "%s: expected %s", key, want)
+	}
+	if f.Type() == durationType {
+		switch v := e.value.(type) {
+		case string:
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				return lineError(e.line, "%s: %v", key, err)
+			}
+			f.SetInt(int64(d))
+		case int64:
+			f.SetInt(v * int64(time.Second))
+		default:
+			return bad(`a duration (ex: "30s")`)
+		}
+		return nil
+	}
+	switch f.Kind() {
+	case reflect.String:
+		v, ok := e.value.(string)
+		if !ok {
+			return bad("a string")
+		}
+		f.SetString(v)
+	case reflect.Bool:
+		v, ok := e.value.(bool)
+		if !ok {
+			return bad("true or false")
+		}
+		f.SetBool(v)
+	case reflect.Int:
+		v, ok := e.value.(int64)
+		if !ok {
+			return bad("an integer")
+		}
+		f.SetInt(v)
+	case reflect.Float64:
+		switch v := e.value.(type) {
+		case float64:
+			f.SetFloat(v)
+		case int64:
+			f.SetFloat(float64(v))
+		default:
+			return bad("a number")
+		}
+	case reflect.Slice:
+		if f.Type().Elem().Kind() == reflect.String {
+			switch v := e.value.(type) {
+			case string:
+				f.Set(reflect.ValueOf([]string{v}))
+			case []interface{}:
+				s := make([]string, len(v))
+				for i, x := range v {
+					str, ok := x.(string)
+					if !ok {
+						return bad("an array of strings")
+					}
+					s[i] = str
+				}
+				f.Set(reflect.ValueOf(s))
+			default:
+				return bad("an array of strings")
+			}
+			return nil
+		}
+		tables, ok := e.value.([]*table)
+		if !ok {
+			return lineError(e.line, "%s: expected [[%s]] section", key, key)
+		}
+		s := reflect.MakeSlice(f.Type(), len(tables), len(tables))
+		for i, t := range tables {
+			if err := decode("[["+key+"]]", t, s.Index(i).Addr()); err != nil {
+				return err
+			}
+		}
+		f.Set(s)
+	case reflect.Struct:
+		t, ok := e.value.(*table)
+		if !ok {
+			return lineError(e.line, "%s: expected [%s] section", key, key)
+		}
+		return decode("["+key+"]", t, f.Addr())
+	default:
+		return fmt.Errorf("conf: unsupported field type %v", f.Type())
+	}
+	return nil
+}
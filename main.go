@@ -4,18 +4,21 @@ import (
 	"encoding/json"
 	"flag"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"net/url"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/StackExchange/slog"
 	"github.com/bosun-monitor/scollector/collect"
 	"github.com/bosun-monitor/scollector/collectors"
+	"github.com/bosun-monitor/scollector/conf"
 	"github.com/bosun-monitor/scollector/metadata"
 	"github.com/bosun-monitor/scollector/opentsdb"
 	"github.com/bosun-monitor/scollector/util"
@@ -33,7 +36,9 @@ var (
 	flagFilter          = flag.String("f", "", "Filters collectors matching this term. Works with all other arguments.")
 	flagList            = flag.Bool("l", false, "List available collectors.")
 	flagPrint           = flag.Bool("p", false, "Print to screen instead of sending to a host")
-	flagHost            = flag.String("h", "", `bosun or OpenTSDB host. Ex: "http://tsdb.example.com:4242".`)
+	flagOnce            = flag.Bool("once", false, "Run collectors once, print their data points and exit. Exits with status 1 if any failed.")
+	flagJSON            = flag.Bool("j", false, "With -once, print JSON instead of a table.")
+	flagHost            = flag.String("h", "", `bosun or OpenTSDB host. Ex: "http://tsdb.example.com:4242". Separate multiple hosts with commas; each receives all data. Use graphite://host:port[?template=...] for Carbon and influx://host:port?db=name for InfluxDB.`)
 	flagColDir          = flag.String("c", "", `External collectors directory.`)
 	flagBatchSize       = flag.Int("b", 0, "OpenTSDB batch size. Used for debugging bad data.")
 	flagSNMP            = flag.String("s", "", "SNMP host to poll of the format: \"community@host[,community@host...]\".")
@@ -45,88 +50,268 @@ var (
 	flagDisableMetadata = flag.Bool("m", false, "Disable sending of metadata.")
 	flagVersion         = flag.Bool("version", false, "Prints the version and exits.")
 	flagDisableDefault  = flag.Bool("n", false, "Disable sending of scollector self metrics.")
+	flagPut             = flag.String("put", "", `Address to accept OpenTSDB telnet-style "put" lines on, over TCP and UDP. Ex: ":4243".`)
+	flagRelay           = flag.String("relay", "", `Address to accept OpenTSDB HTTP /api/put requests on. Ex: ":4242".`)
+	flagStatsD          = flag.String("statsd", "", `Address to accept StatsD packets on over UDP. Values are aggregated and sent every collect frequency. Ex: ":8125".`)
+	flagProm            = flag.String("prom", "", `Address to serve the latest values in Prometheus format at /metrics. Ex: ":9107". Without -h, nothing is pushed.`)
+	flagStatus          = flag.String("status", "", `Address to serve the status and control API on. It has no authentication, so use a local address. Ex: "localhost:4280".`)
+	flagSpool           = flag.String("spool", "", "Directory to spool data to when the queue is full. Spooled data is kept across restarts.")
+	flagDrain           = flag.Duration("drain", time.Second*10, "On SIGTERM or interrupt, how long to try sending queued data before exiting.")
+	flagTimeout         = flag.Duration("timeout", collectors.DefaultTimeout, "How long a collector may take to collect before its results are discarded.")
+	flagProcfs          = flag.String("procfs", collectors.ProcRoot, "Where procfs is mounted. Ex: \"/host/proc\" to monitor the host from a container.")
+	flagSysfs           = flag.String("sysfs", collectors.SysRoot, "Where sysfs is mounted.")
+	flagDockerLabels    = flag.String("dockerlabels", "", `Docker container labels to add as tags to Docker data points, separated by commas. Ex: "com.example.team,env".`)
+	flagCgroupDepth     = flag.Int("cgroupdepth", collectors.CgroupDepth, "How many levels of cgroups below the root to report. Negative for no limit.")
+	flagConf            = flag.String("conf", "", "Configuration file. Defaults to scollector.toml in the executable's directory, if present. Reloaded on SIGHUP.")
 
-	procs []*collectors.WatchedProc
+	// cmdline holds the names of the flags given on the command line.
+	cmdline = make(map[string]bool)
 
 	mains []func()
 )
 
-func readConf() {
-	p, err := exePath()
-	if err != nil {
-		slog.Error(err)
-		return
-	}
-	dir := filepath.Dir(p)
-	p = filepath.Join(dir, "scollector.conf")
-	b, err := ioutil.ReadFile(p)
-	if err != nil {
-		if *flagDebug {
+// readConf reads the configuration file given by -conf, or scollector.toml in
+// the executable's directory if present. Its settings apply to flags not given
+// on the command line. Outputs given with -h replace those of the file.
+func readConf() (*conf.Conf, error) {
+	p := *flagConf
+	if p == "" {
+		exe, err := exePath()
+		if err != nil {
 			slog.Error(err)
+			return new(conf.Conf), nil
 		}
-		return
-	}
-	for i, line := range strings.Split(string(b), "\n") {
-		if strings.TrimSpace(line) == "" {
-			continue
-		}
-		sp := strings.SplitN(line, "=", 2)
-		if len(sp) != 2 {
-			if *flagDebug {
-				slog.Errorf("expected = in %v:%v", p, i+1)
+		dir := filepath.Dir(exe)
+		p = filepath.Join(dir, "scollector.toml")
+		if _, err := os.Stat(p); err != nil {
+			if old := filepath.Join(dir, "scollector.conf"); exists(old) {
+				return nil, fmt.Errorf("%s is no longer read; convert it to %s", old, p)
 			}
-			continue
+			return new(conf.Conf), nil
 		}
-		k := strings.TrimSpace(sp[0])
-		v := strings.TrimSpace(sp[1])
-		f := func(s *string) {
-			if *s == "" {
-				*s = v
-			}
+	}
+	c, err := conf.Load(p)
+	if err != nil {
+		return nil, err
+	}
+	// Settings removed from the file on reload go back to their default.
+	f := func(name, v string) {
+		if cmdline[name] {
+			return
 		}
-		switch k {
-		case "host":
-			f(flagHost)
-		case "filter":
-			f(flagFilter)
-		case "coldir":
-			f(flagColDir)
-		case "snmp":
-			f(flagSNMP)
-		case "icmp":
-			f(flagICMP)
-		case "vsphere":
-			f(flagVsphere)
-		case "process":
-			p, err := collectors.NewWatchedProc(v)
-			if err != nil {
-				slog.Fatal(err)
-			}
-			procs = append(procs, p)
-		default:
-			if *flagDebug {
-				slog.Errorf("unknown key in %v:%v", p, i+1)
-			}
+		if v == "" {
+			v = flag.Lookup(name).DefValue
+		}
+		flag.Set(name, v)
+	}
+	f("f", c.Filter)
+	f("c", c.ColDir)
+	f("spool", c.Spool)
+	f("put", c.Put)
+	f("relay", c.Relay)
+	f("statsd", c.StatsD)
+	f("prom", c.Prom)
+	f("status", c.Status)
+	f("procfs", c.Procfs)
+	f("sysfs", c.Sysfs)
+	f("dockerlabels", c.DockerLabels)
+	depth := ""
+	if c.CgroupDepth != 0 {
+		depth = strconv.Itoa(c.CgroupDepth)
+	}
+	f("cgroupdepth", depth)
+	drain := ""
+	if c.Drain > 0 {
+		drain = c.Drain.String()
+	}
+	f("drain", drain)
+	timeout := ""
+	if c.Timeout > 0 {
+		timeout = c.Timeout.String()
+	}
+	f("timeout", timeout)
+	b := func(v bool) string {
+		if v {
+			return "true"
 		}
+		return ""
 	}
+	batch := ""
+	if c.BatchSize > 0 {
+		batch = strconv.Itoa(c.BatchSize)
+	}
+	f("b", batch)
+	f("u", b(c.FullHost))
+	f("m", b(c.DisableMetadata))
+	f("n", b(c.DisableSelf))
+	if cmdline["h"] {
+		c.Output = nil
+	}
+	return c, nil
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
 }
 
 func main() {
 	flag.Parse()
-	if *flagPrint || *flagDebug {
+	if *flagOnce {
+		slog.Set(&slog.StdLog{Log: log.New(os.Stderr, "", log.LstdFlags)})
+	} else if *flagPrint || *flagDebug {
 		slog.Set(&slog.StdLog{Log: log.New(os.Stdout, "", log.LstdFlags)})
 	}
 	if *flagVersion {
 		fmt.Printf("scollector version %v (%v)\n", VersionDate, VersionID)
 		os.Exit(0)
 	}
+	flag.Visit(func(f *flag.Flag) {
+		cmdline[f.Name] = true
+	})
 	for _, m := range mains {
 		m()
 	}
-	readConf()
+	cf, err := readConf()
+	if err != nil {
+		slog.Fatal(err)
+	}
 
 	util.FullHostname = *flagFullHost
 	util.Set()
+	collectors.ProcRoot = *flagProcfs
+	collectors.SysRoot = *flagSysfs
+	if *flagDockerLabels != "" {
+		collectors.DockerLabels = strings.Split(*flagDockerLabels, ",")
+	}
+	collectors.CgroupDepth = *flagCgroupDepth
+	builtin := collectors.Search("")
+	if err := register(cf); err != nil {
+		slog.Fatal(err)
+	}
+	collectors.DefaultTimeout = *flagTimeout
+	collect.Debug = *flagDebug
+	collect.SpoolDir = *flagSpool
+	if *flagBatchSize > 0 {
+		collect.BatchSize = *flagBatchSize
+	}
+	if *flagDisableDefault {
+		collect.DisableDefaultCollectors = true
+	}
+	c := collectors.Search(*flagFilter)
+	if *flagList {
+		for _, col := range c {
+			col.Init()
+		}
+		list(c)
+		return
+	}
+	if *flagOnce {
+		if !runOnce(c, *flagJSON) {
+			os.Exit(1)
+		}
+		return
+	}
+	outputs, hosts, err := parseHosts(cf.Output)
+	if err != nil {
+		slog.Fatal("invalid host:", err)
+	}
+	if *flagPrint {
+		collectors.DefaultFreq = time.Second * 3
+		slog.Infoln("Set default frequency to", collectors.DefaultFreq)
+		collect.Print = true
+		if len(hosts) > 1 {
+			outputs, hosts = outputs[:1], hosts[:1]
+		}
+	}
+	if !*flagDisableMetadata {
+		for _, u := range hosts {
+			if u.Scheme != "http" && u.Scheme != "https" {
+				continue
+			}
+			if err := metadata.Init(u, *flagDebug); err != nil {
+				slog.Fatal(err)
+			}
+			break
+		}
+	}
+	if *flagProm != "" {
+		if err := collect.ListenPrometheus(*flagProm); err != nil {
+			slog.Fatal(err)
+		}
+	}
+	running := collectors.Run(c)
+	var dests []*collect.Destination
+	for i, u := range hosts {
+		slog.Infoln("host:", redactURL(u))
+		d, err := newDestination(u)
+		if err != nil {
+			slog.Fatal(err)
+		}
+		d.BatchSize = outputs[i].BatchSize
+		d.MaxQueueLen = outputs[i].MaxQueueLen
+		dests = append(dests, d)
+	}
+	if err := collect.InitDestinations(dests, "scollector", running.C); err != nil {
+		slog.Fatal(err)
+	}
+	if *flagStatus != "" {
+		if err := listenStatus(*flagStatus, running, dests); err != nil {
+			slog.Fatal(err)
+		}
+	}
+	if VersionDate > 0 {
+		if err := collect.Put("version", nil, VersionDate); err != nil {
+			slog.Error(err)
+		}
+	}
+	go func() {
+		const maxMem = 500 * 1024 * 1024 // 500MB
+		var m runtime.MemStats
+		for _ = range time.Tick(time.Minute) {
+			runtime.ReadMemStats(&m)
+			if m.Alloc > maxMem {
+				panic("memory max reached")
+			}
+		}
+	}()
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGHUP, syscall.SIGTERM, os.Interrupt)
+	for s := range sig {
+		if s == syscall.SIGHUP {
+			reload(running, builtin)
+			continue
+		}
+		slog.Infoln("received", s, "shutting down")
+		shutdown(running, *flagDrain)
+		os.Exit(0)
+	}
+}
+
+// shutdown stops the collectors and sends what they and the collect package
+// have pending, giving up after timeout.
+func shutdown(running *collectors.Handle, timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	running.StopAll()
+	stopped := make(chan struct{})
+	go func() {
+		running.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(deadline.Sub(time.Now())):
+		slog.Errorln("collectors still running:", strings.Join(running.Stopping(), ", "))
+	}
+	if n := collect.Shutdown(deadline); n > 0 {
+		slog.Errorf("abandoned %d data points", n)
+	} else {
+		slog.Infoln("all data sent")
+	}
+}
+
+// register registers the collectors given by flags and cf.
+func register(cf *conf.Conf) error {
 	if *flagColDir != "" {
 		collectors.InitPrograms(*flagColDir)
 	}
@@ -134,95 +319,95 @@ func main() {
 		for _, s := range strings.Split(*flagSNMP, ",") {
 			sp := strings.Split(s, "@")
 			if len(sp) != 2 {
-				slog.Fatal("invalid snmp string:", *flagSNMP)
+				return fmt.Errorf("invalid snmp string: %s", *flagSNMP)
 			}
-			collectors.SNMPIfaces(sp[0], sp[1])
-			collectors.SNMPCisco(sp[0], sp[1])
+			collectors.SNMPIfaces(sp[0], sp[1], 0)
+			collectors.SNMPCisco(sp[0], sp[1], 0)
 		}
 	}
+	for _, s := range cf.SNMP {
+		collectors.SNMPIfaces(s.Community, s.Host, s.Interval)
+		collectors.SNMPCisco(s.Community, s.Host, s.Interval)
+	}
 	if *flagICMP != "" {
 		for _, s := range strings.Split(*flagICMP, ",") {
-			collectors.ICMP(s)
+			collectors.ICMP(s, 0)
 		}
 	}
+	for _, i := range cf.ICMP {
+		collectors.ICMP(i.Host, i.Interval)
+	}
 	if *flagVsphere != "" {
 		for _, s := range strings.Split(*flagVsphere, ",") {
 			sp := strings.SplitN(s, ":", 2)
 			if len(sp) != 2 {
-				slog.Fatal("invalid vsphere string:", *flagVsphere)
+				return fmt.Errorf("invalid vsphere string: %s", *flagVsphere)
 			}
 			user := sp[0]
 			idx := strings.LastIndex(sp[1], "@")
 			if idx == -1 {
-				slog.Fatal("invalid vsphere string:", *flagVsphere)
+				return fmt.Errorf("invalid vsphere string: %s", *flagVsphere)
 			}
 			pwd := sp[1][:idx]
 			host := sp[1][idx+1:]
 			if len(user) == 0 || len(pwd) == 0 || len(host) == 0 {
-				slog.Fatal("invalid vsphere string:", *flagVsphere)
+				return fmt.Errorf("invalid vsphere string: %s", *flagVsphere)
 			}
-			collectors.Vsphere(user, pwd, host)
+			collectors.Vsphere(user, pwd, host, 0)
 		}
 	}
+	for _, v := range cf.Vsphere {
+		collectors.Vsphere(v.User, v.Password, v.Host, v.Interval)
+	}
+	for _, p := range cf.Program {
+		collectors.AddProgram(p.Path, p.Interval)
+	}
+	var procs []*collectors.WatchedProc
+	for _, p := range cf.Process {
+		w, err := collectors.NewWatchedProc(strings.Join([]string{p.Command, p.Name, p.Args}, ","))
+		if err != nil {
+			return err
+		}
+		procs = append(procs, w)
+	}
 	if len(procs) > 0 {
 		if err := collectors.WatchProcesses(procs); err != nil {
-			log.Fatal(err)
+			return err
 		}
 	}
-	if *flagFake > 0 {
-		collectors.InitFake(*flagFake)
-	}
-	collect.Debug = *flagDebug
-	if *flagDisableDefault {
-		collect.DisableDefaultCollectors = true
-	}
-	c := collectors.Search(*flagFilter)
-	for _, col := range c {
-		col.Init()
+	if *flagPut != "" {
+		collectors.ListenPut(*flagPut)
 	}
-	u, err := parseHost()
-	if *flagList {
-		list(c)
-		return
-	} else if err != nil {
-		slog.Fatal("invalid host:", *flagHost)
+	if *flagRelay != "" {
+		collectors.ListenRelay(*flagRelay)
 	}
-	if *flagPrint {
-		collectors.DefaultFreq = time.Second * 3
-		slog.Infoln("Set default frequency to", collectors.DefaultFreq)
-		collect.Print = true
+	if *flagStatsD != "" {
+		collectors.ListenStatsD(*flagStatsD, collect.Freq)
 	}
-	if !*flagDisableMetadata {
-		if err := metadata.Init(u, *flagDebug); err != nil {
-			slog.Fatal(err)
-		}
-	}
-	cdp := collectors.Run(c)
-	if u != nil {
-		slog.Infoln("OpenTSDB host:", u)
-	}
-	if err := collect.InitChan(u, "scollector", cdp); err != nil {
-		slog.Fatal(err)
+	if *flagFake > 0 {
+		collectors.InitFake(*flagFake)
 	}
-	if VersionDate > 0 {
-		if err := collect.Put("version", nil, VersionDate); err != nil {
-			slog.Error(err)
-		}
+	return nil
+}
+
+// reload re-reads the configuration file and updates the running collectors
+// to match it. builtin are the collectors registered before the configuration
+// was first applied. Outputs and other settings used at startup are unchanged.
+func reload(running *collectors.Handle, builtin []collectors.Collector) {
+	slog.Infoln("reloading configuration")
+	cf, err := readConf()
+	if err != nil {
+		slog.Errorf("reload: %v", err)
+		return
 	}
-	if *flagBatchSize > 0 {
-		collect.BatchSize = *flagBatchSize
+	current := collectors.Search("")
+	collectors.Restore(builtin)
+	if err := register(cf); err != nil {
+		slog.Errorf("reload: %v", err)
+		collectors.Restore(current)
+		return
 	}
-	go func() {
-		const maxMem = 500 * 1024 * 1024 // 500MB
-		var m runtime.MemStats
-		for _ = range time.Tick(time.Minute) {
-			runtime.ReadMemStats(&m)
-			if m.Alloc > maxMem {
-				panic("memory max reached")
-			}
-		}
-	}()
-	select {}
+	running.Update(collectors.Search(*flagFilter))
 }
 
 func exePath() (string, error) {
@@ -257,14 +442,66 @@ func list(cs []collectors.Collector) {
 	}
 }
 
-func parseHost() (*url.URL, error) {
-	if *flagHost == "" {
-		*flagHost = "bosun"
+// parseHosts returns the outputs of the configuration file, or those given by -h
+// if there are none, and their parsed URLs.
+func parseHosts(outputs []conf.Output) ([]conf.Output, []*url.URL, error) {
+	if len(outputs) == 0 {
+		if *flagHost == "" {
+			if *flagProm != "" {
+				// Pull only.
+				return nil, nil, nil
+			}
+			*flagHost = "bosun"
+		}
+		for _, h := range strings.Split(*flagHost, ",") {
+			outputs = append(outputs, conf.Output{URL: h})
+		}
+	}
+	var hosts []*url.URL
+	for _, o := range outputs {
+		h := strings.TrimSpace(o.URL)
+		if !strings.Contains(h, "//") {
+			h = "http://" + h
+		}
+		u, err := url.Parse(h)
+		if err != nil {
+			return nil, nil, err
+		}
+		hosts = append(hosts, u)
+	}
+	return outputs, hosts, nil
+}
+
+// redactURL returns u with its password, if any, masked for logging.
+func redactURL(u *url.URL) *url.URL {
+	r := *u
+	if r.User == nil {
+		return &r
+	}
+	if _, ok := r.User.Password(); ok {
+		r.User = url.UserPassword(r.User.Username(), "xxxxx")
 	}
-	if !strings.Contains(*flagHost, "//") {
-		*flagHost = "http://" + *flagHost
+	return &r
+}
+
+// newDestination returns a destination for u based on its scheme:
+// graphite://host:port sends to a Carbon plaintext listener,
+// influx://host:port?db=name (or influxs:// for HTTPS) to InfluxDB, and
+// anything else to OpenTSDB.
+func newDestination(u *url.URL) (*collect.Destination, error) {
+	switch u.Scheme {
+	case "graphite":
+		return collect.NewGraphite(u.Host, u.Query().Get("template"))
+	case "influx", "influxs":
+		h := *u
+		h.Scheme = "http"
+		if u.Scheme == "influxs" {
+			h.Scheme = "https"
+		}
+		return collect.NewInflux(&h, u.Query().Get("db"))
+	default:
+		return collect.NewOpenTSDB(u)
 	}
-	return url.Parse(*flagHost)
 }
 
 func printPut(c chan *opentsdb.DataPoint) {
@@ -38,6 +38,7 @@ const (
 	Entropy             = "entropy"
 	Event               = ""
 	Fault               = "faults"
+	Hour                = "hours"
 	Interupt            = "interupts"
 	KBytes              = "kbytes"
 	Load                = "load"
@@ -75,6 +76,7 @@ func (m Metakey) TagSet() opentsdb.TagSet {
 
 var (
 	metadata  = make(map[Metakey]interface{})
+	byMetric  = make(map[string]map[string]interface{})
 	metalock  sync.Mutex
 	metahost  string
 	metafuncs []func()
@@ -102,6 +104,18 @@ func AddMeta(metric string, tags opentsdb.TagSet, name string, value interface{}
 		slog.Infof("AddMeta for %s/%s/%s: %v", metric, ts, name, value)
 	}
 	metadata[Metakey{metric, ts, name}] = value
+	if byMetric[metric] == nil {
+		byMetric[metric] = make(map[string]interface{})
+	}
+	byMetric[metric][name] = value
+}
+
+// Lookup returns the most recently added value of metadata name for metric,
+// regardless of tags, or nil if there is none.
+func Lookup(metric, name string) interface{} {
+	metalock.Lock()
+	defer metalock.Unlock()
+	return byMetric[metric][name]
 }
 
 func Init(u *url.URL, debug bool) error {
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/bosun-monitor/scollector/collectors"
+	"github.com/bosun-monitor/scollector/metadata"
+	"github.com/bosun-monitor/scollector/opentsdb"
+)
+
+// onceResult is the outcome of a collector run by -once.
+type onceResult struct {
+	Collector  string          `json:"collector"`
+	Error      string          `json:"error,omitempty"`
+	Skipped    string          `json:"skipped,omitempty"`
+	DataPoints []onceDataPoint `json:"datapoints"`
+}
+
+type onceDataPoint struct {
+	Metric    string          `json:"metric"`
+	Timestamp int64           `json:"timestamp"`
+	Value     interface{}     `json:"value"`
+	Tags      opentsdb.TagSet `json:"tags"`
+	Rate      interface{}     `json:"rate,omitempty"`
+	Unit      interface{}     `json:"unit,omitempty"`
+	Desc      interface{}     `json:"desc,omitempty"`
+}
+
+// runOnce runs each of cs a single time and prints the data points they
+// produced, with their metadata, as a table or as JSON. Collectors that do not
+// run at an interval or are not enabled on this host are skipped. It returns
+// false if any collector failed.
+func runOnce(cs []collectors.Collector, asJSON bool) bool {
+	ok := true
+	results := make([]onceResult, 0, len(cs))
+	for _, c := range cs {
+		r := onceResult{Collector: c.Name(), DataPoints: []onceDataPoint{}}
+		md, err := collectors.Once(c)
+		switch err {
+		case nil:
+		case collectors.ErrNotEnabled, collectors.ErrContinuous:
+			r.Skipped = err.Error()
+		default:
+			r.Error = err.Error()
+			ok = false
+		}
+		for _, dp := range md {
+			r.DataPoints = append(r.DataPoints, onceDataPoint{
+				Metric:    dp.Metric,
+				Timestamp: dp.Timestamp,
+				Value:     dp.Value,
+				Tags:      dp.Tags,
+				Rate:      metadata.Lookup(dp.Metric, "rate"),
+				Unit:      metadata.Lookup(dp.Metric, "unit"),
+				Desc:      metadata.Lookup(dp.Metric, "desc"),
+			})
+		}
+		results = append(results, r)
+	}
+	if asJSON {
+		b, err := json.MarshalIndent(results, "", "  ")
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return false
+		}
+		fmt.Printf("%s\n", b)
+		return ok
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "METRIC\tTAGS\tVALUE\tRATE\tUNIT\tDESCRIPTION")
+	for _, r := range results {
+		for _, dp := range r.DataPoints {
+			fmt.Fprintf(w, "%s\t%s\t%v\t%s\t%s\t%s\n", dp.Metric, dp.Tags, dp.Value, orNone(dp.Rate), orNone(dp.Unit), orNone(dp.Desc))
+		}
+	}
+	w.Flush()
+	for _, r := range results {
+		switch {
+		case r.Error != "":
+			fmt.Fprintf(os.Stderr, "%s: error: %s\n", r.Collector, r.Error)
+		case r.Skipped != "":
+			fmt.Fprintf(os.Stderr, "%s: skipped: %s\n", r.Collector, r.Skipped)
+		default:
+			fmt.Fprintf(os.Stderr, "%s: %d data points\n", r.Collector, len(r.DataPoints))
+		}
+	}
+	return ok
+}
+
+// orNone formats a metadata value, using "-" for a missing one.
+func orNone(v interface{}) string {
+	s := fmt.Sprint(v)
+	if v == nil || s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+
+	"github.com/StackExchange/slog"
+	"github.com/bosun-monitor/scollector/collect"
+	"github.com/bosun-monitor/scollector/collectors"
+)
+
+// statusServer serves the state of the collectors and destinations, and lets
+// collectors be run, disabled and enabled.
+type statusServer struct {
+	addr    string
+	running *collectors.Handle
+	dests   []*collect.Destination
+}
+
+// statusActionHeader must be set on action requests. Browsers do not send
+// custom headers to another origin without asking first, so web pages cannot
+// trigger actions.
+const statusActionHeader = "X-Scollector-Action"
+
+type status struct {
+	Collectors   []collectors.Status         `json:"collectors"`
+	Destinations []collect.DestinationStatus `json:"destinations"`
+}
+
+// listenStatus serves the status API on addr:
+//
+//	GET /api/status
+//	POST /api/run?collector=name
+//	POST /api/disable?collector=name
+//	POST /api/enable?collector=name
+func listenStatus(addr string, running *collectors.Handle, dests []*collect.Destination) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	s := &statusServer{addr, running, dests}
+	go func() {
+		slog.Error(http.Serve(l, s.handler()))
+	}()
+	return nil
+}
+
+func (s *statusServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/status", s.status)
+	mux.HandleFunc("/api/run", s.action(func(name string) error {
+		return s.running.RunNow(name)
+	}))
+	mux.HandleFunc("/api/disable", s.action(func(name string) error {
+		_, err := s.running.Disable(name)
+		return err
+	}))
+	mux.HandleFunc("/api/enable", s.action(s.running.Enable))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.validHost(r.Host) {
+			http.Error(w, "invalid host", http.StatusForbidden)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// validHost reports whether host, from the Host header of a request, names the
+// listen address, so that pages of other sites cannot reach the API through a
+// DNS name rebound to it. If the address has no host, loopback names are
+// accepted.
+func (s *statusServer) validHost(host string) bool {
+	if host == s.addr {
+		return true
+	}
+	lhost, lport, err := net.SplitHostPort(s.addr)
+	if err != nil || lhost != "" {
+		return false
+	}
+	h, port, err := net.SplitHostPort(host)
+	if err != nil || port != lport {
+		return false
+	}
+	return h == "localhost" || h == "127.0.0.1" || h == "::1"
+}
+
+func (s *statusServer) status(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	st := status{
+		Collectors:   s.running.Status(),
+		Destinations: make([]collect.DestinationStatus, len(s.dests)),
+	}
+	for i, d := range s.dests {
+		st.Destinations[i] = d.Status()
+	}
+	b, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+// action returns a handler that calls f with the collector named by the
+// collector query parameter.
+func (s *statusServer) action(f func(name string) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if r.Header.Get(statusActionHeader) == "" {
+			http.Error(w, "missing "+statusActionHeader+" header", http.StatusForbidden)
+			return
+		}
+		name := r.FormValue("collector")
+		if name == "" {
+			http.Error(w, "missing collector", http.StatusBadRequest)
+			return
+		}
+		if err := f(name); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		slog.Infoln("status:", r.URL.Path, name, "from", r.RemoteAddr)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bosun-monitor/scollector/collectors"
+	"github.com/bosun-monitor/scollector/opentsdb"
+)
+
+func TestStatusServer(t *testing.T) {
+	c := &collectors.IntervalCollector{
+		F: func() (opentsdb.MultiDataPoint, error) {
+			return nil, nil
+		},
+		Interval: time.Hour,
+	}
+	running := collectors.Run([]collectors.Collector{c})
+	defer running.StopAll()
+	go func() {
+		for range running.C {
+		}
+	}()
+	run := "/api/run?collector=" + c.Name()
+	for _, test := range []struct {
+		addr, method, host, path string
+		action                   bool
+		code                     int
+	}{
+		{"localhost:4280", "GET", "localhost:4280", "/api/status", false, http.StatusOK},
+		{"localhost:4280", "GET", "evil.example.com:4280", "/api/status", false, http.StatusForbidden},
+		{"localhost:4280", "POST", "localhost:4280", run, true, http.StatusNoContent},
+		{"localhost:4280", "POST", "localhost:4280", run, false, http.StatusForbidden},
+		{"localhost:4280", "POST", "127.0.0.1:4280", run, true, http.StatusForbidden},
+		{":4280", "POST", "127.0.0.1:4280", run, true, http.StatusNoContent},
+		{":4280", "POST", "[::1]:4280", run, true, http.StatusNoContent},
+		{":4280", "POST", "127.0.0.1:80", run, true, http.StatusForbidden},
+		{":4280", "POST", "evil.example.com:4280", run, true, http.StatusForbidden},
+	} {
+		s := &statusServer{addr: test.addr, running: running}
+		r := httptest.NewRequest(test.method, "http://"+test.host+test.path, nil)
+		if test.action {
+			r.Header.Set(statusActionHeader, "1")
+		}
+		w := httptest.NewRecorder()
+		s.handler().ServeHTTP(w, r)
+		if w.Code != test.code {
+			t.Errorf("%s %s to %s on %s: got %d, expected %d", test.method, test.path, test.host, test.addr, w.Code, test.code)
+		}
+	}
+}